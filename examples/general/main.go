@@ -5,6 +5,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"os"
 )
 
 //go:generate go build -o gnob -tags gnob ./...
@@ -62,6 +63,16 @@ func main() {
 				p = p.Pipe("tee", "hello.out")
 				return p.Run()
 			},
+		},
+		GnobMakeTarget{
+			Name: "test",
+			Desc: "Test the example",
+			Body: func(ctx context.Context, mf *GnobMakefile) error {
+				if err := mf.WriteDocs(os.Stdout, "markdown"); err != nil {
+					return err
+				}
+				return mf.Depend(ctx, "default")
+			},
 		})
 	mf.Run(ctx)
 }
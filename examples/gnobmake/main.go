@@ -4,6 +4,7 @@ package main
 
 import (
 	"context"
+	"os"
 )
 
 //go:generate go build -o gnob -tags gnob ./...
@@ -17,7 +18,7 @@ var (
 
 func main() {
 	gnob.GoRebuildYourself("*.go")
-	mf := makefile.New(Default, TaskOne, TaskTwo, TaskThree)
+	mf := makefile.New(Default, TaskOne, TaskTwo, TaskThree, Test)
 	mf.Run(context.Background())
 }
 
@@ -64,3 +65,15 @@ var TaskThree = GnobMakeTarget{
 		return nil
 	},
 }
+
+var Test = GnobMakeTarget{
+	Name:     "test",
+	Desc:     "Test the example",
+	LongDesc: "This prints the documentation of the targets, and runs the default target",
+	Body: func(ctx context.Context, mf *GnobMakefile) error {
+		if err := mf.WriteDocs(os.Stdout, "markdown"); err != nil {
+			return err
+		}
+		return mf.Depend(ctx, "default")
+	},
+}
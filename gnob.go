@@ -354,32 +354,597 @@
 // Default Target
 // ```
 //
+// When no target is given on the command line, the target with `Default: true` is executed.
+// If there is none, gnob shows a numbered menu of the targets that are not hidden when running in a terminal,
+// from which a target is picked by number, or by typing part of its name or description to filter the menu.
+// Otherwise, it fails with the list of targets instead of guessing.
+//
+// #### Dependencies
+//
+// Dependencies between targets can be declared with the `Deps` field of a `GnobMakeTarget`.
+// They are executed in order before the target's `UpToDate` function is checked.
+// Unknown dependencies and dependency cycles are reported as an error before any target runs,
+// along with targets that share a name, several targets with `Default: true`, and targets without a `Body`.
+// The same checks are available from `mf.Validate()`, which reports all the problems together.
+// Unknown targets, on the command line or in `Deps`, are reported with the closest target names,
+// like `unknown target: tset (did you mean test?)`.
+//
+// ```go
+// GnobMakeTarget{
+// 	Name: "all",
+// 	Deps: []string{"build", "test"},
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		return nil
+// 	},
+// }
+// ```
+//
+// Dependencies can also be executed from within the `Body` of a target with `mf.Depend`,
+// or concurrently with `mf.DependParallel`. The number of concurrent targets, counted across all the calls
+// to `mf.DependParallel`, defaults to the number of CPUs, and can be changed with `gnob -j N <target>`.
+// A target waiting for its dependencies does not count against that limit.
+//
+// Every target runs at most once per invocation of `gnob`, even if several targets depend on it.
+//
+// Several targets can be run from a single invocation, like `gnob test example`.
+// Each target is followed by its own flags and arguments, and an argument that names a known target starts the next one.
+// Arguments after `--` always belong to the current target, as in `gnob run -- test`.
+// The targets are executed in order, or concurrently with `gnob -parallel test example`.
+//
+// The dependency graph can be exported in the Graphviz DOT format or as JSON with `gnob -graph[=dot|json] [target]`.
+// When a target is given, it is executed first, so that the dependencies it takes with `mf.Depend` are included in the graph.
+// The graph is also available programmatically from `mf.Graph()`.
+//
+// #### Pattern Targets
+//
+// Targets whose names follow a pattern can be declared once with a `GnobPatternTarget`,
+// like the `%.o: %.c` rules of Make. The `Name` contains a single `%`, which matches any non-empty stem.
+// The target is created when it is first referenced, with every `%` in its `Deps`, `Inputs`, and `Outputs`
+// replaced by the stem, and the stem is passed to its `Body`.
+// If several patterns match, the one with the shortest stem is used.
+//
+// ```go
+// mf.AddPattern(GnobPatternTarget{
+// 	Name:    "bin/%",
+// 	Inputs:  []string{"cmd/%/*.go"},
+// 	Outputs: []string{"bin/%"},
+// 	Body: func(ctx context.Context, mf *GnobMakefile, stem string) error {
+// 		return GnobLib.Cmd.Exec(ctx, "go", "build", "-o", "bin/"+stem, "./cmd/"+stem).Run()
+// 	},
+// })
+// ```
+//
+// #### Namespaces
+//
+// Target names can be qualified with a namespace, like `docker:build` or `go:test`,
+// and `gnob -help` lists the targets of each namespace under their own heading.
+// A target can be referred to by its short name, like `gnob lint` for `go:lint`, as long as only one target has it;
+// otherwise the fully-qualified name is required.
+//
+// A `GnobMakefile` can be mounted inside another under a namespace with `Mount`, which is useful to split a large build
+// into several files. The targets of the mounted Makefile keep referring to each other by their own names.
+//
+// ```go
+// docker := GnobLib.Makefile.New(
+// 	GnobMakeTarget{Name: "build", Body: dockerBuild},
+// 	GnobMakeTarget{Name: "push", Deps: []string{"build"}, Body: dockerPush},
+// )
+// mf := GnobLib.Makefile.New(targets...)
+// mf.Mount("docker", docker) // adds docker:build and docker:push
+// mf.Run(ctx)
+// ```
+//
+// #### Subprojects
+//
+// Another gnob project in a subdirectory, like the `build/` directory of a component, can be used with
+// `GnobLib.Makefile.Project(dir)`. Its `Run` method builds the gnob binary of the project if any of its sources
+// is newer, like `RebuildYourself`, and executes it in the directory of the project.
+// `mf.Import` adds the targets of the project under a namespace, so that `gnob -help` lists them,
+// and `gnob examples/docs:test` runs `gnob test` in `examples/docs`.
+// The arguments of an imported target are passed on to the gnob binary of the project.
+//
+// ```go
+// if err := mf.Import(ctx, "examples/docs", GnobLib.Makefile.Project("examples/docs")); err != nil {
+// 	GnobLogger.Error("unable to import examples/docs", "error", err)
+// 	os.Exit(1)
+// }
+// ```
+//
+// #### Target Flags
+//
+// Targets can declare the flags they accept on the command line with the `Flags` field,
+// using `GnobLib.Makefile.BoolFlag`, `StringFlag`, `IntFlag`, or `DurationFlag`.
+// They are parsed from the arguments following the target, like `gnob build -race -tags=foo`,
+// and their values are read from the `Body` with `mf.Bool`, `mf.String`, `mf.Int`, and `mf.Duration`.
+// Invalid or unknown flags are reported as an error, and the flags are listed by `gnob -help <target>`.
+// The remaining arguments are returned by `mf.TargetArgs()`. The arguments of a target without `Flags` are not
+// parsed, so `gnob test -v ./...` passes `-v ./...` to it as they are.
+//
+// ```go
+// GnobMakeTarget{
+// 	Name: "build",
+// 	Flags: []GnobTargetFlag{
+// 		GnobLib.Makefile.BoolFlag("race", false, "enable the race detector"),
+// 		GnobLib.Makefile.StringFlag("tags", "", "comma-separated list of build tags"),
+// 	},
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		args := []string{"build", "-tags", mf.String("tags")}
+// 		if mf.Bool("race") {
+// 			args = append(args, "-race")
+// 		}
+// 		return GnobLib.Cmd.Exec(ctx, "go", append(args, "./...")...).Run()
+// 	},
+// }
+// ```
+//
+// #### Timeouts and Retries
+//
+// A target can limit how long its `Body` may take with `Timeout`, and be retried when it fails with `Retries`.
+// The context passed to the `Body` is cancelled when the timeout expires, and the target fails with
+// an error matching `GnobErrTargetTimeout`, which is reported as `timeout` in the summary.
+// Retries wait for `RetryBackoff`, one second by default, doubling the delay after each retry.
+//
+// ```go
+// GnobMakeTarget{
+// 	Name:         "download",
+// 	Timeout:      30 * time.Second,
+// 	Retries:      3,
+// 	RetryBackoff: 2 * time.Second,
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		return GnobLib.Cmd.Exec(ctx, "curl", "-fsSLO", "https://example.com/archive.tar.gz").Run()
+// 	},
+// }
+// ```
+//
+// #### Lifecycle Hooks
+//
+// Notifications, metrics, and custom logging can be plugged into a Makefile without wrapping every `Body`,
+// by registering hooks with `mf.OnTargetStart`, `mf.OnTargetFinish`, `mf.OnUpToDate`, and `mf.OnError`,
+// which receive a `GnobTargetEvent` with the target, its result, duration, and error,
+// and with `mf.BeforeRun` and `mf.AfterRun`, which are called around the whole run.
+// The logging of the targets is itself done with hooks.
+//
+// ```go
+// mf.OnError(func(ctx context.Context, ev GnobTargetEvent) {
+// 	notify(fmt.Sprintf("%s failed after %s: %v", ev.Target.Name, ev.Duration, ev.Err))
+// })
+// ```
+//
+// #### Variables
+//
+// Like `make build GOOS=linux`, variables are set with `KEY=value` arguments on the command line,
+// and read from the `Body` of a target with `mf.Var`, `mf.VarBool`, `mf.VarInt`, and `mf.VarDuration`.
+// A variable that is not set on the command line is taken from the environment, then from the variables file,
+// and finally from the `Default` declared with `mf.AddVars`. Declared variables are listed by `gnob -help`
+// with their current value. The variables file is `gnob.env`, with `KEY=value` lines, or `gnob.json`,
+// with a JSON object of strings, numbers, and booleans, unless another file is given with `-vars file`.
+//
+// ```go
+// mf.AddVars(GnobMakeVar{Name: "GOOS", Default: "linux", Usage: "target operating system"})
+// ```
+//
+// ```shell
+// ./gnob build GOOS=windows
+// ```
+//
+// #### Command Line
+//
+// Global flags are given before the targets, with either one or two dashes:
+//
+//   - `-help`, `-h`: show the targets, or the help of the given target
+//   - `-v`, `-q`: show debug messages, or only warnings and errors, overriding `GNOB_LOG_LEVEL`
+//   - `-C dir`: change to `dir` before running the targets
+//   - `-j N`: maximum number of targets executed at the same time
+//   - `-parallel`: execute the targets given on the command line concurrently
+//   - `-n`: print the targets that would run, in order, without executing them
+//   - `-k`: keep going after a target fails, skipping only the targets that depend on it,
+//     instead of stopping at the first error
+//   - `-summary-json file`: write the summary of the run as JSON to `file`, for CI dashboards
+//   - `-trace file`: write a trace of the targets, the commands they execute, and the rebuild of gnob to `file`,
+//     in the Chrome trace-event format that can be loaded in [Perfetto](https://ui.perfetto.dev)
+//   - `-vars file`: read the variables from `file` instead of `gnob.env` or `gnob.json`
+//   - `-list[=json]`: list the targets, one per line with their description, or as JSON
+//   - `-graph[=dot|json]`: show the dependency graph
+//   - `-watch`: execute the targets again each time the files they are built from change
+//   - `-completion shell`: print the completion script for `bash`, `zsh`, or `fish`
+//   - `-gen-docs=markdown|man|file`: print the documentation of the targets as Markdown, as a man page,
+//     or with the template `file`
+//
+// When the run finishes, a summary of every executed target is printed with its result
+// (success, failure, skipped, or up-to-date) and wall time, followed by the critical path:
+// the chain of dependencies that determined how long the run took.
+// The summary is also available programmatically from `mf.Summary()`.
+//
+// In dry-run mode, with `gnob -n <target>`, the `UpToDate` functions are evaluated, but the `Body` of a target is
+// only called if its `EchoCommands` field is true. Like `make -n`, a target whose dependencies would run is printed
+// as one that would run too, even if its files are up-to-date. The commands such a target executes with `GnobLib.Cmd.Exec`
+// are printed, shell-quoted, instead of executed. The same can be done outside of a Makefile with
+// `GnobLib.Cmd.DryRun(ctx, os.Stdout)`.
+//
+// Shell completion of the targets, the global flags, and the flags of each target is enabled by loading
+// the output of `-completion` in the shell, like `source <(./gnob -completion bash)`.
+// The scripts ask gnob for the candidates each time, with the hidden `__complete` command,
+// so they stay in sync with the targets of the current build file.
+//
+// In watch mode, with `gnob -watch <target>`, the files the targets are built from are checked for changes every
+// `GnobWatchInterval`: the `Inputs` of the targets and their dependencies, and the files given to `FileUpToDate`,
+// `HashUpToDate`, and `StateUpToDate`. The `Outputs` of the targets are not watched. When a file changes,
+// the context of the current run is cancelled, and the targets are executed again once the files stop changing,
+// after a separator line. This replaces loops with tools like `entr`.
+//
+// The documentation generated with `-gen-docs` lists every target that is not hidden, with its description,
+// dependencies, flags, and whether it is the default target. It is also available from `mf.WriteDocs(w, format)`,
+// and `mf.Docs()` returns the `GnobMakeDocs` that the templates are executed with. A different layout is used
+// by giving the path of a template file, or a template parsed with `GnobLib.Template` to `mf.WriteDocsTemplate`.
+//
+// #### Up-to-date Checks
+//
+// A target is skipped when its `UpToDate` function returns true.
+// `GnobLib.Makefile.FileUpToDate(target, sources...)` compares modification times,
+// while `GnobLib.Makefile.HashUpToDate(target, sources...)` compares the SHA-256 digests of the file contents,
+// so it is not fooled by `git checkout`, restored CI caches, or copied files.
+// The digests are stored in the `.gnob/` directory, which should be ignored by version control.
+//
+// Instead of an `UpToDate` function, a target that builds files can declare its `Inputs` and `Outputs`,
+// which may be glob patterns. The target is skipped when every output exists and is newer than all the inputs,
+// and it fails if one of its outputs is missing after its `Body` succeeds.
+// The inputs and outputs are listed by `gnob -help <target>`.
+//
+// ```go
+// GnobMakeTarget{
+// 	Name:    "bin/app",
+// 	Inputs:  []string{"go.mod", "*.go"},
+// 	Outputs: []string{"bin/app"},
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		return GnobLib.Cmd.Exec(ctx, "go", "build", "-o", "bin/app", ".").Run()
+// 	},
+// }
+// ```
+//
+// Targets that do not produce a single file, like running tests, can use
+// `GnobLib.Makefile.StateUpToDate(inputs, outputs)`. It records the last run time, duration, input digests,
+// outputs, and result of the target in the `.gnob/state` database, and skips the target
+// if its last run succeeded and none of its inputs have changed since.
+// The recorded state can be queried with `mf.State(name)`.
+//
 
 package main
 
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"runtime/debug"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 	"text/template"
 	"time"
 	"unicode"
 )
 
+// completeCommand is the hidden command that the completion scripts execute to complete the command line,
+// like `gnob __complete -j 4 bu`. The last argument is the word being completed, and may be empty.
+const GnobcompleteCommand = "__complete"
+
+// completionScripts are the completion scripts for each shell, printed by `gnob -completion <shell>`.
+// They are formatted with the name of the command, and the name of the command usable as a shell identifier.
+var GnobcompletionScripts = map[string]string{
+	"bash": `_%[2]s_completion() {
+	local line="${COMP_LINE:0:COMP_POINT}" words
+	read -ra words <<< "$line"
+	[[ "$line" == *" " ]] && words+=("")
+	local cur="${words[-1]}" prefix=""
+	[[ "$cur" == *:* && "$COMP_WORDBREAKS" == *:* ]] && prefix="${cur%%"${cur##*:}"}"
+	local IFS=$'\n' c
+	COMPREPLY=()
+	for c in $("${words[0]}" __complete "${words[@]:1}" 2>/dev/null); do
+		COMPREPLY+=("${c#"$prefix"}")
+	done
+}
+complete -o default -F _%[2]s_completion %[1]s ./%[1]s
+`,
+	"zsh": `#compdef %[1]s ./%[1]s
+_%[2]s_completion() {
+	local -a candidates
+	candidates=(${(f)"$(${words[1]} __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ${#candidates} )); then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _%[2]s_completion %[1]s ./%[1]s
+`,
+	"fish": `function __%[2]s_completion
+	set -l tokens (commandline -opc) (commandline -ct)
+	$tokens[1] __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c %[1]s -c ./%[1]s -a '(__%[2]s_completion)'
+`,
+}
+
+// showCompletion prints the completion script for the given shell.
+func (mf *GnobMakefile) showCompletion(shell string) error {
+	script, ok := GnobcompletionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unknown shell for completion: %s", shell)
+	}
+	name := filepath.Base(mf.name)
+	ident := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	fmt.Printf(script, name, ident)
+	return nil
+}
+
+// showCompletions prints the candidates for the last word of the command line, one per line.
+// Words starting with a dash are completed with the global flags before the first target,
+// and with the flags of the target after it. Other words are completed with the names of the targets
+// that are not hidden, and nothing is printed after `--`, or when the word is the value of a flag.
+func (mf *GnobMakefile) showCompletions(words []string) error {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	fs, _ := mf.optionSet()
+	for i := 0; i < len(words)-1; i++ {
+		word := words[i]
+		switch {
+		case word == "--":
+			return nil
+		case strings.HasPrefix(word, "-") && len(word) > 1:
+			if GnobtakesValue(fs, word) {
+				if i++; i == len(words)-1 {
+					return nil
+				}
+			}
+		default:
+			if tgt := mf.Find(word); tgt != nil {
+				fs = tgt.flagSet()
+			}
+		}
+	}
+	if strings.HasPrefix(cur, "-") {
+		dashes := "-"
+		if strings.HasPrefix(cur, "--") {
+			dashes = "--"
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			if strings.HasPrefix(f.Name, strings.TrimLeft(cur, "-")) {
+				fmt.Println(dashes + f.Name)
+			}
+		})
+		return nil
+	}
+	for _, tgt := range mf.targets {
+		if !tgt.Hidden && strings.HasPrefix(tgt.Name, cur) {
+			fmt.Println(tgt.Name)
+		}
+	}
+	return nil
+}
+
+// MakeDocs is the documentation of the targets of a Makefile, which is the data of the documentation templates.
+type GnobMakeDocs struct {
+	// Name is the name of the program.
+	Name string
+	// Flags are the global flags of the Makefile.
+	Flags []GnobMakeDocsFlag
+	// Targets are the targets and pattern targets that are not hidden, sorted by name, the default target first.
+	Targets []GnobMakeDocsTarget
+}
+
+// MakeDocsTarget is the documentation of a target in MakeDocs.
+type GnobMakeDocsTarget struct {
+	// Name is the name of the target.
+	Name string
+	// Desc is the short description of the target.
+	Desc string
+	// LongDesc is the long description of the target.
+	LongDesc string
+	// Default is true if the target is the default target.
+	Default bool
+	// Pattern is true if the target is a pattern target.
+	Pattern bool
+	// Deps are the names of the targets the target depends on.
+	Deps []string
+	// Flags are the flags the target accepts on the command line.
+	Flags []GnobMakeDocsFlag
+}
+
+// MakeDocsFlag is the documentation of a flag in MakeDocs.
+type GnobMakeDocsFlag struct {
+	// Name is the name of the flag, without the leading dash.
+	Name string
+	// Usage is the description of the flag.
+	Usage string
+	// Default is the default value of the flag, as text.
+	Default string
+}
+
+// docsTemplates are the built-in templates of WriteDocs, by format.
+var GnobdocsTemplates = map[string]string{
+	"markdown": `# {{ .Name }}
+
+Run ` + "`{{ .Name }} <target>`" + ` to execute a target.
+{{- range .Targets }}
+
+## ` + "`{{ .Name }}`" + `{{ if .Default }} (default){{ end }}{{ if .Pattern }} (pattern){{ end }}
+{{- with .Desc }}
+
+{{ . }}
+{{- end }}
+{{- with .LongDesc }}
+
+{{ . }}
+{{- end }}
+{{- with .Deps }}
+
+Dependencies: {{ range $i, $dep := . }}{{ if $i }}, {{ end }}` + "`{{ $dep }}`" + `{{ end }}
+{{- end }}
+{{- with .Flags }}
+
+| Flag | Default | Description |
+| ---- | ------- | ----------- |
+{{- range . }}
+| ` + "`-{{ .Name }}`" + ` | {{ with .Default }}` + "`{{ . }}`" + `{{ end }} | {{ .Usage }} |
+{{- end }}
+{{- end }}
+{{- end }}
+`,
+	"man": `.TH {{ upper .Name }} 1
+.SH NAME
+{{ man .Name }} \- build targets
+.SH SYNOPSIS
+.B {{ man .Name }}
+[\fIflags\fR] [\fItarget\fR [\fIflags\fR] [\fIargs\fR]...]
+.SH OPTIONS
+{{- range .Flags }}
+.TP
+.B \-{{ man .Name }}
+{{ man .Usage }}
+{{- end }}
+.SH TARGETS
+{{- range .Targets }}
+.TP
+.B {{ man .Name }}{{ if .Default }} (default){{ end }}{{ if .Pattern }} (pattern){{ end }}
+{{- with .Desc }}
+{{ man . }}
+{{- end }}
+{{- with .LongDesc }}
+.IP
+{{ man . }}
+{{- end }}
+{{- with .Deps }}
+.IP
+Dependencies: {{ man (join . ", ") }}
+{{- end }}
+{{- range .Flags }}
+.IP
+\fB\-{{ man .Name }}\fR{{ with .Default }} (default {{ man . }}){{ end }}: {{ man .Usage }}
+{{- end }}
+{{- end }}
+`,
+}
+
+// docsFuncs are the template functions of the documentation templates, in addition to those of Template.
+func GnobdocsFuncs() template.FuncMap {
+	return template.FuncMap{
+		"join":  strings.Join,
+		"upper": strings.ToUpper,
+		// man escapes the text for a man page
+		"man": func(s string) string {
+			lines := strings.Split(strings.ReplaceAll(s, `\`, `\e`), "\n")
+			for i, line := range lines {
+				if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+					lines[i] = `\&` + line
+				}
+			}
+			return strings.Join(lines, "\n")
+		},
+	}
+}
+
+// docsFlags returns the documentation of the flags of the flag set.
+func GnobdocsFlags(fs *flag.FlagSet) []GnobMakeDocsFlag {
+	var flags []GnobMakeDocsFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, GnobMakeDocsFlag{Name: f.Name, Usage: f.Usage, Default: f.DefValue})
+	})
+	return flags
+}
+
+// Docs returns the documentation of the targets of the Makefile.
+func (mf *GnobMakefile) Docs() *GnobMakeDocs {
+	fs, _ := mf.optionSet()
+	docs := &GnobMakeDocs{
+		Name:  filepath.Base(mf.name),
+		Flags: GnobdocsFlags(fs),
+	}
+	for i, tgt := range mf.targets {
+		if tgt.Hidden {
+			continue
+		}
+		docs.Targets = append(docs.Targets, GnobMakeDocsTarget{
+			Name:     tgt.Name,
+			Desc:     tgt.Desc,
+			LongDesc: tgt.LongDesc,
+			Default:  i == mf.defaultTarget,
+			Deps:     tgt.Deps,
+			Flags:    GnobdocsFlags(tgt.flagSet()),
+		})
+	}
+	for _, pt := range mf.patterns {
+		if pt.Hidden {
+			continue
+		}
+		docs.Targets = append(docs.Targets, GnobMakeDocsTarget{
+			Name:     pt.Name,
+			Desc:     pt.Desc,
+			LongDesc: pt.LongDesc,
+			Pattern:  true,
+			Deps:     pt.Deps,
+			Flags:    GnobdocsFlags((&GnobMakeTarget{Name: pt.Name, Flags: pt.Flags}).flagSet()),
+		})
+	}
+	return docs
+}
+
+// WriteDocs writes the documentation of the targets of the Makefile to w, in the given format.
+// The format is either markdown, man for a man page, or the path of a template file to use another layout.
+// Templates are parsed with Template, and executed with the MakeDocs of the Makefile.
+// In addition to the functions of Template, they can use `join`, `upper`, and `man`, which escapes text for a man page.
+// This can also be done on the command line with `gnob -gen-docs=markdown`.
+func (mf *GnobMakefile) WriteDocs(w io.Writer, format string) error {
+	var (
+		t   Gnob_template
+		tpl *template.Template
+		err error
+	)
+	if text, ok := GnobdocsTemplates[format]; ok {
+		tpl, err = t.ParseTextFuncs(text, GnobdocsFuncs())
+	} else if _, statErr := os.Stat(format); statErr == nil {
+		tpl, err = t.ParseFileFuncs(format, GnobdocsFuncs())
+	} else {
+		return fmt.Errorf("unknown documentation format: %s", format)
+	}
+	if err != nil {
+		return err
+	}
+	return mf.WriteDocsTemplate(w, tpl)
+}
+
+// WriteDocsTemplate writes the documentation of the targets of the Makefile to w, using the given template.
+func (mf *GnobMakefile) WriteDocsTemplate(w io.Writer, tpl *template.Template) error {
+	if err := tpl.Execute(w, mf.Docs()); err != nil {
+		return fmt.Errorf("unable to write documentation: %w", err)
+	}
+	return nil
+}
+
 // ExecOption is the interface for options to customize the command.
 type GnobExecOption interface {
 	apply(*GnobcmdOptions)
@@ -478,11 +1043,35 @@ type GnobExec struct {
 	closers   []io.Closer
 	onExit    []func()
 	exitCodes []int
+	dryRun    bool
+	span      *GnobtraceSpan
 }
 
 type Gnob_cmd struct {
 }
 
+type GnobdryRunKey struct{}
+
+// DryRun returns a context in which the commands created with Exec are printed to w, shell-quoted,
+// instead of being executed.
+// This is used by the Makefile in dry-run mode.
+func (Gnob_cmd) DryRun(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, GnobdryRunKey{}, w)
+}
+
+// shellQuote returns the arguments quoted for a POSIX shell.
+func GnobshellQuote(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
 // Exec creates a new command.
 // The output of this command can be chained into other commands with Pipe and Pipe2.
 // For example:
@@ -606,20 +1195,27 @@ func (e *GnobExec) Run() error {
 // Start starts the command chain.
 // It returns the first error encountered.
 // It does not wait for the command to finish, to wait for the command to finish, use Wait.
+// If the context was created with DryRun, the command chain is printed instead of started.
 func (e *GnobExec) Start() error {
+	if w, ok := e.ctx.Value(GnobdryRunKey{}).(io.Writer); ok {
+		return e.printDryRun(w)
+	}
 	this := e
 	if this.cmd.Stderr == nil {
 		this.cmd.Stderr = &this.stderr
 	} else {
 		this.cmd.Stderr = io.MultiWriter(&this.stderr, e.cmd.Stderr)
 	}
-	var chain []*exec.Cmd
+	var chain []*GnobExec
 	for this != nil {
-		chain = append(chain, this.cmd)
+		chain = append(chain, this)
 		this = this.prev
 	}
 	for i := len(chain) - 1; i >= 0; i-- {
-		if err := chain[i].Start(); err != nil {
+		c := chain[i]
+		c.span = Gnobtraces.start("command", filepath.Base(c.cmd.Path), map[string]any{"cmd": GnobshellQuote(c.cmd.Args)})
+		if err := c.cmd.Start(); err != nil {
+			c.span.end(err)
 			return err
 		}
 	}
@@ -631,6 +1227,9 @@ func (e *GnobExec) Start() error {
 // To get the exit code of the last command, use ExitCode.
 // To get the exit codes of all commands, use ExitCodes.
 func (e *GnobExec) Wait() error {
+	if e.dryRun {
+		return nil
+	}
 	this := e
 	var chain []*GnobExec
 	for this != nil {
@@ -645,7 +1244,9 @@ func (e *GnobExec) Wait() error {
 			for _, c := range chain[i].closers {
 				_ = c.Close()
 			}
-			errCh <- chain[i].cmd.Wait()
+			err := chain[i].cmd.Wait()
+			chain[i].span.end(err)
+			errCh <- err
 		}()
 		select {
 		case <-e.ctx.Done():
@@ -670,6 +1271,22 @@ func (e *GnobExec) Wait() error {
 	return nil
 }
 
+// printDryRun prints the command chain to w instead of starting it.
+func (e *GnobExec) printDryRun(w io.Writer) error {
+	var chain []string
+	for this := e; this != nil; this = this.prev {
+		line := GnobshellQuote(this.cmd.Args)
+		if this.cmd.Dir != "" {
+			line = "cd " + GnobshellQuote([]string{this.cmd.Dir}) + " && " + line
+		}
+		chain = append(chain, line)
+	}
+	slices.Reverse(chain)
+	e.dryRun = true
+	_, err := fmt.Fprintf(w, "  %s\n", strings.Join(chain, " | "))
+	return err
+}
+
 // ExitCode returns the exit code of the last command.
 func (e *GnobExec) ExitCode() int {
 	if len(e.exitCodes) == 0 {
@@ -816,66 +1433,528 @@ func (f Gnob_files) TargetNeedsUpdate(target string, sources ...string) bool {
 	return b.After(a)
 }
 
-// Lib is the library of functions used by gnob.
-var GnobLib Gnob_lib
+// Digest returns the hex-encoded SHA-256 digest of the contents of the file.
+func (f Gnob_files) Digest(file string) (string, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file %q: %w", file, err)
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, fd); err != nil {
+		return "", fmt.Errorf("unable to read file %q: %w", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-// Lib is the library of functions used by gnob.
+// Digests expands glob patterns and returns the SHA-256 digests of all matched files, by path.
+// Directories matched by a pattern are skipped.
+func (f Gnob_files) Digests(files ...string) (map[string]string, error) {
+	digests := make(map[string]string)
+	for _, pattern := range files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("unable to expand glob %q: %w", pattern, err)
+		}
+		for _, p := range matches {
+			if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+				continue
+			}
+			digest, err := f.Digest(p)
+			if err != nil {
+				return nil, err
+			}
+			digests[p] = digest
+		}
+	}
+	return digests, nil
+}
 
-// Lib is the library of functions used by gnob.
-type Gnob_lib struct {
-	// Main is the root of the library.
-	Main Gnob_root
-	// Template is a collection of operations using Go templates.
-	Template Gnob_template
-	// Files is a collection of operations on files.
-	Files Gnob_files
-	// Cmd is a collection of operations to run commands.
-	Cmd Gnob_cmd
-	// Make has operations to construct a Makefile and MakeTargets.
-	Makefile Gnob_makefile
+// TargetFlag is a flag accepted by a MakeTarget on the command line, like `gnob build -race -tags=foo`.
+// Use BoolFlag, StringFlag, IntFlag, or DurationFlag to create one.
+type GnobTargetFlag struct {
+	// Name is the name of the flag, without the leading dash.
+	Name string
+	// Usage is a short description of the flag.
+	// It is shown when running `gnob -help <target>`.
+	Usage string
+	// define adds the flag to a flag set.
+	define func(fs *flag.FlagSet)
 }
 
-var (
-	GnobLogger = GnobdefaultLogger()
-)
+// BoolFlag returns a boolean flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.Bool.
+func (Gnob_makefile) BoolFlag(name string, value bool, usage string) GnobTargetFlag {
+	return GnobTargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.Bool(name, value, usage)
+	}}
+}
 
-func GnobSetLogger(logger *slog.Logger) {
-	GnobLogger = logger
+// StringFlag returns a string flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.String.
+func (Gnob_makefile) StringFlag(name string, value string, usage string) GnobTargetFlag {
+	return GnobTargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.String(name, value, usage)
+	}}
 }
 
-type Gnob_logHandler struct {
-	start  time.Time
-	output io.Writer
-	*slog.TextHandler
+// IntFlag returns an integer flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.Int.
+func (Gnob_makefile) IntFlag(name string, value int, usage string) GnobTargetFlag {
+	return GnobTargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.Int(name, value, usage)
+	}}
 }
 
-func (h *Gnob_logHandler) Handle(_ context.Context, r slog.Record) error {
-	var line strings.Builder
-	dur := r.Time.Sub(h.start)
-	line.WriteString(fmt.Sprintf("[%04d] ", int(dur.Seconds())))
-	switch r.Level {
-	case slog.LevelDebug:
-		line.WriteString("DEBUG ")
-	case slog.LevelInfo:
-		line.WriteString("INFO  ")
-	case slog.LevelWarn:
-		line.WriteString("WARN  ")
-	case slog.LevelError:
-		line.WriteString("ERROR ")
+// DurationFlag returns a duration flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.Duration.
+func (Gnob_makefile) DurationFlag(name string, value time.Duration, usage string) GnobTargetFlag {
+	return GnobTargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.Duration(name, value, usage)
+	}}
+}
+
+// flagSet returns a flag set with the flags of the target, set to their default values.
+func (mt *GnobMakeTarget) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(mt.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	for _, f := range mt.Flags {
+		if f.define == nil {
+			GnobLogger.Warn("[gnob:makefile] ignoring flag without a type", "target", mt.Name, "flag", f.Name)
+			continue
+		}
+		f.define(fs)
 	}
-	line.WriteString(r.Message)
-	first := true
-	if n := r.NumAttrs(); n > 0 {
-		line.WriteString(" {")
-		r.Attrs(func(attr slog.Attr) bool {
-			if !first {
-				line.WriteByte(' ')
-			}
-			line.WriteString(attr.String())
-			first = false
-			return true
-		})
-		line.WriteByte('}')
+	return fs
+}
+
+// commandLine is the flags and arguments given to a target on the command line.
+type GnobcommandLine struct {
+	flags *flag.FlagSet
+	args  []string
+}
+
+// parseCommandLine parses the targets to run from the argument list, like `gnob build -race pkg test example`.
+// Each target is followed by its flags and arguments. The arguments of targets without Flags are not parsed as flags.
+// An argument that names a known target starts the next target,
+// unless it follows a `--`, after which all the arguments belong to the current target.
+// The `KEY=value` arguments before the `--` that are not flag values set variables, like `gnob build GOOS=linux`,
+// and can be followed by more flags.
+// The flags and arguments of each target are recorded so that they are available when it executes.
+func (mf *GnobMakefile) parseCommandLine(args []string) ([]*GnobMakeTarget, error) {
+	var targets []*GnobMakeTarget
+	for len(args) > 0 {
+		if mf.parseVar(args[0]) {
+			args = args[1:]
+			continue
+		}
+		tgt, err := mf.lookup(args[0])
+		if err != nil {
+			return nil, err
+		}
+		fs := tgt.flagSet()
+		var targetArgs []string
+		// targets without flags get their arguments as they are, like `gnob test -v ./...`
+		rest, parse := args[1:], len(tgt.Flags) > 0
+	scan:
+		for len(rest) > 0 {
+			if parse {
+				if err := fs.Parse(rest); err != nil {
+					if errors.Is(err, flag.ErrHelp) {
+						return []*GnobMakeTarget{tgt}, err
+					}
+					return nil, fmt.Errorf("target %s: %w", tgt.Name, err)
+				}
+				if consumed := len(rest) - fs.NArg(); consumed > 0 && rest[consumed-1] == "--" {
+					targetArgs = append(targetArgs, fs.Args()...)
+					rest = nil
+					break
+				}
+				rest, parse = fs.Args(), false
+			}
+			for len(rest) > 0 {
+				switch arg := rest[0]; {
+				case arg == "--":
+					targetArgs = append(targetArgs, rest[1:]...)
+					rest = nil
+				case mf.parseVar(arg):
+					rest = rest[1:]
+					// flags can follow a variable, like `gnob build GOOS=linux -race`
+					if len(tgt.Flags) > 0 {
+						parse = true
+						continue scan
+					}
+				case mf.Find(arg) != nil:
+					break scan
+				default:
+					targetArgs = append(targetArgs, arg)
+					rest = rest[1:]
+				}
+			}
+		}
+		mf.runsMu.Lock()
+		if mf.commandLines == nil {
+			mf.commandLines = make(map[*GnobMakeTarget]GnobcommandLine)
+		}
+		mf.commandLines[tgt] = GnobcommandLine{flags: fs, args: slices.Clip(targetArgs)}
+		mf.runsMu.Unlock()
+		targets = append(targets, tgt)
+		args = rest
+	}
+	return targets, nil
+}
+
+// flagValue returns the value of the flag with the given name of the executing target.
+// It returns nil if the Makefile is not executing a target, or the target has no such flag.
+func (mf *GnobMakefile) flagValue(name string) any {
+	if mf.run == nil || mf.run.cmd.flags == nil {
+		return nil
+	}
+	f := mf.run.cmd.flags.Lookup(name)
+	if f == nil {
+		GnobLogger.Warn("[gnob:makefile] unknown flag", "target", mf.target.Name, "flag", name)
+		return nil
+	}
+	return f.Value.(flag.Getter).Get()
+}
+
+// Bool returns the value of the boolean flag with the given name of the executing target.
+func (mf *GnobMakefile) Bool(name string) bool {
+	v, _ := mf.flagValue(name).(bool)
+	return v
+}
+
+// String returns the value of the string flag with the given name of the executing target.
+func (mf *GnobMakefile) String(name string) string {
+	v, _ := mf.flagValue(name).(string)
+	return v
+}
+
+// Int returns the value of the integer flag with the given name of the executing target.
+func (mf *GnobMakefile) Int(name string) int {
+	v, _ := mf.flagValue(name).(int)
+	return v
+}
+
+// Duration returns the value of the duration flag with the given name of the executing target.
+func (mf *GnobMakefile) Duration(name string) time.Duration {
+	v, _ := mf.flagValue(name).(time.Duration)
+	return v
+}
+
+// showFlags prints the flags of the target.
+func (mt *GnobMakeTarget) showFlags() {
+	if len(mt.Flags) == 0 {
+		return
+	}
+	fs := mt.flagSet()
+	fs.SetOutput(os.Stdout)
+	fmt.Println()
+	fmt.Println("Flags:")
+	fs.PrintDefaults()
+}
+
+// makeEdge is a dependency from one target to another that was taken during a run.
+type GnobmakeEdge struct {
+	from *GnobMakeTarget
+	to   *GnobMakeTarget
+}
+
+// MakeGraph is the dependency graph of the targets in a Makefile.
+type GnobMakeGraph struct {
+	// Nodes are the targets of the Makefile.
+	Nodes []GnobMakeGraphNode `json:"nodes"`
+	// Edges are the dependencies between the targets.
+	Edges []GnobMakeGraphEdge `json:"edges"`
+}
+
+// MakeGraphNode is a target in the MakeGraph.
+type GnobMakeGraphNode struct {
+	// Name is the name of the target.
+	Name string `json:"name"`
+	// Desc is the short description of the target.
+	Desc string `json:"desc,omitempty"`
+	// Hidden is true if the target is hidden from listing.
+	Hidden bool `json:"hidden"`
+	// Default is true if the target is the default target.
+	Default bool `json:"default"`
+	// UpToDate is true if the target is up-to-date, according to its UpToDate function,
+	// or otherwise to the modification times of its Inputs and Outputs.
+	// It is always false for targets without an UpToDate function or Outputs.
+	UpToDate bool `json:"upToDate"`
+}
+
+// MakeGraphEdge is a dependency between two targets in the MakeGraph.
+type GnobMakeGraphEdge struct {
+	// From is the name of the dependent target.
+	From string `json:"from"`
+	// To is the name of the dependency.
+	To string `json:"to"`
+	// Dynamic is true if the dependency is not declared in Deps,
+	// but was recorded when the target called Depend during a run.
+	Dynamic bool `json:"dynamic"`
+}
+
+// Graph returns the dependency graph of the Makefile.
+// The graph contains the dependencies declared with Deps,
+// and the dependencies recorded from calls to Depend while the Makefile was running.
+// Targets created from pattern targets are included once they have been referenced.
+// Every target is checked for being up-to-date to populate the graph, like when it is executed.
+func (mf *GnobMakefile) Graph() *GnobMakeGraph {
+	targets := append(slices.Clip(mf.targets), mf.patternInstances()...)
+	g := &GnobMakeGraph{
+		Nodes: make([]GnobMakeGraphNode, 0, len(targets)),
+	}
+	static := make(map[GnobmakeEdge]struct{})
+	for i, tgt := range targets {
+		g.Nodes = append(g.Nodes, GnobMakeGraphNode{
+			Name:     tgt.Name,
+			Desc:     tgt.Desc,
+			Hidden:   tgt.Hidden,
+			Default:  i == mf.defaultTarget,
+			UpToDate: tgt.upToDate(&GnobMakefile{GnobmakefileState: mf.GnobmakefileState, target: tgt}),
+		})
+		for _, dep := range tgt.Deps {
+			to := mf.Find(dep)
+			if to == nil {
+				continue
+			}
+			static[GnobmakeEdge{from: tgt, to: to}] = struct{}{}
+			g.Edges = append(g.Edges, GnobMakeGraphEdge{From: tgt.Name, To: to.Name})
+		}
+	}
+	mf.runsMu.Lock()
+	var dynamic []GnobMakeGraphEdge
+	for e := range mf.edges {
+		if _, ok := static[e]; ok {
+			continue
+		}
+		dynamic = append(dynamic, GnobMakeGraphEdge{From: e.from.Name, To: e.to.Name, Dynamic: true})
+	}
+	mf.runsMu.Unlock()
+	slices.SortFunc(dynamic, func(a, b GnobMakeGraphEdge) int {
+		if c := strings.Compare(a.From, b.From); c != 0 {
+			return c
+		}
+		return strings.Compare(a.To, b.To)
+	})
+	g.Edges = append(g.Edges, dynamic...)
+	return g
+}
+
+// WriteJSON writes the graph as JSON to w.
+func (g *GnobMakeGraph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language to w.
+// The default target is drawn in bold, hidden targets are dashed, and up-to-date targets are filled.
+// Dynamic dependencies are drawn as dashed edges.
+func (g *GnobMakeGraph) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph gnob {\n")
+	sb.WriteString("  node [shape=box];\n")
+	for _, n := range g.Nodes {
+		var style []string
+		if n.Default {
+			style = append(style, "bold")
+		}
+		if n.Hidden {
+			style = append(style, "dashed")
+		}
+		if n.UpToDate {
+			style = append(style, "filled")
+		}
+		fmt.Fprintf(&sb, "  %q", n.Name)
+		if len(style) > 0 {
+			fmt.Fprintf(&sb, " [style=%q]", strings.Join(style, ","))
+		}
+		sb.WriteString(";\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&sb, "  %q -> %q", e.From, e.To)
+		if e.Dynamic {
+			sb.WriteString(" [style=dashed]")
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// showGraph prints the dependency graph in the given format.
+// If targets are given on the command line, they are executed first,
+// so that the dependencies they take with Depend are recorded in the graph.
+func (mf *GnobMakefile) showGraph(ctx context.Context, format string) error {
+	var write func(g *GnobMakeGraph, w io.Writer) error
+	switch format {
+	case "", "dot":
+		write = (*GnobMakeGraph).WriteDOT
+	case "json":
+		write = (*GnobMakeGraph).WriteJSON
+	default:
+		return fmt.Errorf("unknown graph format: %s", format)
+	}
+	targets, err := mf.parseCommandLine(mf.commandArgs)
+	if err != nil {
+		return err
+	}
+	for _, tgt := range targets {
+		if err = tgt.exec(ctx, mf); err != nil {
+			return err
+		}
+	}
+	return write(mf.Graph(), os.Stdout)
+}
+
+// TargetEvent describes a target to the lifecycle hooks of a Makefile.
+type GnobTargetEvent struct {
+	// Target is the target the event is about.
+	Target *GnobMakeTarget
+	// Result is one of TargetSucceeded, TargetFailed, TargetTimedOut, or TargetUpToDate.
+	// It is empty when the target starts, and in dry-run mode.
+	Result string
+	// Duration is how long the target took, since it started.
+	Duration time.Duration
+	// Err is the error of the target, if it failed.
+	Err error
+}
+
+// TargetHook is a function called on a lifecycle event of a target.
+// Targets can execute concurrently, so hooks can be called concurrently too.
+type GnobTargetHook func(ctx context.Context, ev GnobTargetEvent)
+
+// makeHooks are the lifecycle hooks registered on a Makefile.
+type GnobmakeHooks struct {
+	targetStart  []GnobTargetHook
+	targetFinish []GnobTargetHook
+	upToDate     []GnobTargetHook
+	onError      []GnobTargetHook
+	beforeRun    []func(ctx context.Context, targets []*GnobMakeTarget)
+	afterRun     []func(ctx context.Context, err error)
+}
+
+// OnTargetStart registers a hook that is called when a target starts, after its dependencies finished.
+func (mf *GnobMakefile) OnTargetStart(fn GnobTargetHook) {
+	mf.hooks.targetStart = append(mf.hooks.targetStart, fn)
+}
+
+// OnTargetFinish registers a hook that is called when a target that started finishes, whatever its result.
+func (mf *GnobMakefile) OnTargetFinish(fn GnobTargetHook) {
+	mf.hooks.targetFinish = append(mf.hooks.targetFinish, fn)
+}
+
+// OnUpToDate registers a hook that is called when a target is skipped because it is up-to-date.
+func (mf *GnobMakefile) OnUpToDate(fn GnobTargetHook) {
+	mf.hooks.upToDate = append(mf.hooks.upToDate, fn)
+}
+
+// OnError registers a hook that is called when a target fails or times out.
+func (mf *GnobMakefile) OnError(fn GnobTargetHook) {
+	mf.hooks.onError = append(mf.hooks.onError, fn)
+}
+
+// BeforeRun registers a hook that is called with the targets given on the command line, before they are executed.
+func (mf *GnobMakefile) BeforeRun(fn func(ctx context.Context, targets []*GnobMakeTarget)) {
+	mf.hooks.beforeRun = append(mf.hooks.beforeRun, fn)
+}
+
+// AfterRun registers a hook that is called with the result of the run, after all the targets finished.
+// The summary of the run is available from Summary.
+func (mf *GnobMakefile) AfterRun(fn func(ctx context.Context, err error)) {
+	mf.hooks.afterRun = append(mf.hooks.afterRun, fn)
+}
+
+// emit calls the hooks with the event.
+func Gnobemit(ctx context.Context, hooks []GnobTargetHook, ev GnobTargetEvent) {
+	for _, fn := range hooks {
+		fn(ctx, ev)
+	}
+}
+
+// logHooks registers the hooks that log the lifecycle of the targets.
+func (mf *GnobMakefile) logHooks() {
+	mf.OnTargetStart(func(ctx context.Context, ev GnobTargetEvent) {
+		GnobLogger.DebugContext(ctx, "[gnob:makefile] execute target", "target", ev.Target.Name)
+	})
+	mf.OnUpToDate(func(ctx context.Context, ev GnobTargetEvent) {
+		GnobLogger.InfoContext(ctx, "[gnob:makefile] target is up-to-date", "target", ev.Target.Name)
+	})
+	mf.OnError(func(ctx context.Context, ev GnobTargetEvent) {
+		GnobLogger.ErrorContext(ctx, "[gnob:makefile] error executing target", "target", ev.Target.Name, "error", ev.Err)
+	})
+}
+
+// Lib is the library of functions used by gnob.
+var GnobLib Gnob_lib
+
+// Lib is the library of functions used by gnob.
+
+// Lib is the library of functions used by gnob.
+type Gnob_lib struct {
+	// Main is the root of the library.
+	Main Gnob_root
+	// Template is a collection of operations using Go templates.
+	Template Gnob_template
+	// Files is a collection of operations on files.
+	Files Gnob_files
+	// Cmd is a collection of operations to run commands.
+	Cmd Gnob_cmd
+	// Make has operations to construct a Makefile and MakeTargets.
+	Makefile Gnob_makefile
+}
+
+var (
+	GnoblogLevel = new(slog.LevelVar)
+	GnobLogger   = GnobdefaultLogger()
+)
+
+func GnobSetLogger(logger *slog.Logger) {
+	GnobLogger = logger
+}
+
+// SetLogLevel sets the minimum level of the messages written by the default Logger.
+// It overrides the level set by the GNOB_LOG_LEVEL environment variable.
+func GnobSetLogLevel(level slog.Level) {
+	GnoblogLevel.Set(level)
+}
+
+type Gnob_logHandler struct {
+	start  time.Time
+	output io.Writer
+	*slog.TextHandler
+}
+
+func (h *Gnob_logHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+	dur := r.Time.Sub(h.start)
+	line.WriteString(fmt.Sprintf("[%04d] ", int(dur.Seconds())))
+	switch r.Level {
+	case slog.LevelDebug:
+		line.WriteString("DEBUG ")
+	case slog.LevelInfo:
+		line.WriteString("INFO  ")
+	case slog.LevelWarn:
+		line.WriteString("WARN  ")
+	case slog.LevelError:
+		line.WriteString("ERROR ")
+	}
+	line.WriteString(r.Message)
+	first := true
+	if n := r.NumAttrs(); n > 0 {
+		line.WriteString(" {")
+		r.Attrs(func(attr slog.Attr) bool {
+			if !first {
+				line.WriteByte(' ')
+			}
+			line.WriteString(attr.String())
+			first = false
+			return true
+		})
+		line.WriteByte('}')
 	}
 	line.WriteString("\n")
 	_, err := h.output.Write([]byte(line.String()))
@@ -884,17 +1963,17 @@ func (h *Gnob_logHandler) Handle(_ context.Context, r slog.Record) error {
 
 func GnobdefaultLogger() *slog.Logger {
 	opts := &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: GnoblogLevel,
 	}
 	switch os.Getenv(GnobEnvLogLevel) {
 	case "debug":
-		opts.Level = slog.LevelDebug
+		GnoblogLevel.Set(slog.LevelDebug)
 	case "info":
-		opts.Level = slog.LevelInfo
+		GnoblogLevel.Set(slog.LevelInfo)
 	case "warn":
-		opts.Level = slog.LevelWarn
+		GnoblogLevel.Set(slog.LevelWarn)
 	case "error":
-		opts.Level = slog.LevelError
+		GnoblogLevel.Set(slog.LevelError)
 	}
 	return slog.New(
 		&Gnob_logHandler{
@@ -905,6 +1984,697 @@ func GnobdefaultLogger() *slog.Logger {
 	)
 }
 
+// namespaceOf returns the namespace of a target name, which is everything before its last ':'.
+// For example, the namespace of `docker:build` is `docker`. Names without a ':' have no namespace.
+func GnobnamespaceOf(name string) string {
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// namespaces returns the namespaces of the visible targets and pattern targets, sorted by name.
+// Targets without a namespace come first.
+func (mf *GnobMakefile) namespaces() []string {
+	namespaces := []string{""}
+	for _, tgt := range mf.targets {
+		if !tgt.Hidden {
+			namespaces = append(namespaces, GnobnamespaceOf(tgt.Name))
+		}
+	}
+	for _, pt := range mf.patterns {
+		if !pt.Hidden {
+			namespaces = append(namespaces, GnobnamespaceOf(pt.Name))
+		}
+	}
+	slices.Sort(namespaces)
+	return slices.Compact(namespaces)
+}
+
+// lookup returns the target with the given name, as described by Find.
+// It returns an error if the target is not found, or if the short name is ambiguous.
+func (mf *GnobMakefile) lookup(name string) (*GnobMakeTarget, error) {
+	if mf.target != nil && mf.target.namespace != "" {
+		if tgt := mf.findExact(mf.target.namespace + ":" + name); tgt != nil {
+			return tgt, nil
+		}
+	}
+	if tgt := mf.findExact(name); tgt != nil {
+		return tgt, nil
+	}
+	suffix := ":" + strings.ToLower(name)
+	var found []*GnobMakeTarget
+	for _, tgt := range mf.targets {
+		if strings.HasSuffix(strings.ToLower(tgt.Name), suffix) {
+			found = append(found, tgt)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("unknown target: %s%s", name, mf.suggest(name))
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, 0, len(found))
+	for _, tgt := range found {
+		names = append(names, tgt.Name)
+	}
+	return nil, fmt.Errorf("ambiguous target: %s could be %s", name, strings.Join(names, ", "))
+}
+
+// findExact returns the target with the given fully-qualified name,
+// or the target created from the pattern target that matches it.
+func (mf *GnobMakefile) findExact(name string) *GnobMakeTarget {
+	for _, tgt := range mf.targets {
+		if strings.EqualFold(tgt.Name, name) {
+			return tgt
+		}
+	}
+	return mf.findPattern(name)
+}
+
+// Mount adds the targets and pattern targets of another Makefile under the given namespace,
+// so that the `build` target of other becomes the `prefix:build` target of mf.
+// Their dependencies are renamed in the same way, and the names the mounted targets pass to Find, Depend,
+// and DependParallel are resolved in the namespace first, so they keep referring to each other.
+// The default target of other is not the default target of mf, and its global flags and hooks are not mounted.
+func (mf *GnobMakefile) Mount(prefix string, other *GnobMakefile) {
+	qualify := func(deps []string, resolve func(string) *GnobMakeTarget) []string {
+		if deps == nil {
+			return nil
+		}
+		qualified := make([]string, 0, len(deps))
+		for _, dep := range deps {
+			if tgt := resolve(dep); tgt != nil {
+				dep = tgt.Name
+			}
+			qualified = append(qualified, prefix+":"+dep)
+		}
+		return qualified
+	}
+	namespace := func(ns string) string {
+		if ns == "" {
+			return prefix
+		}
+		return prefix + ":" + ns
+	}
+	for _, tgt := range other.targets {
+		mounted := *tgt
+		mounted.Name = prefix + ":" + tgt.Name
+		mounted.Default = false
+		mounted.Deps = qualify(tgt.Deps, (&GnobMakefile{GnobmakefileState: other.GnobmakefileState, target: tgt}).Find)
+		mounted.namespace = namespace(tgt.namespace)
+		mf.targets = append(mf.targets, &mounted)
+	}
+	for _, pt := range other.patterns {
+		mounted := *pt
+		mounted.Name = prefix + ":" + pt.Name
+		mounted.Deps = qualify(pt.Deps, func(dep string) *GnobMakeTarget {
+			if strings.Contains(dep, "%") {
+				return nil
+			}
+			return other.Find(dep)
+		})
+		mounted.namespace = namespace(pt.namespace)
+		mf.patterns = append(mf.patterns, &mounted)
+	}
+	mf.patternsMu.Lock()
+	mf.instances = nil
+	mf.patternsMu.Unlock()
+	mf.normalize()
+}
+
+// makeOptions are the global flags of the Makefile, given on the command line before the targets.
+type GnobmakeOptions struct {
+	help       bool
+	verbose    bool
+	quiet      bool
+	dir        string
+	jobs       int
+	parallel   bool
+	dryRun     bool
+	keepGoing  bool
+	summary    string
+	trace      string
+	list       GnobformatValue
+	graph      GnobformatValue
+	completion string
+	watch      bool
+	genDocs    string
+	vars       string
+}
+
+// formatValue is a flag that can be given with or without an output format, like `-graph` or `-graph=json`.
+// It is empty when the flag is not given.
+type GnobformatValue string
+
+func (v *GnobformatValue) String() string {
+	return string(*v)
+}
+
+func (v *GnobformatValue) Set(s string) error {
+	if b, err := strconv.ParseBool(s); err == nil {
+		if !b {
+			*v = ""
+			return nil
+		}
+		s = "text"
+	}
+	*v = GnobformatValue(s)
+	return nil
+}
+
+func (v *GnobformatValue) IsBoolFlag() bool {
+	return true
+}
+
+// optionSet returns the flag set of the global flags of the Makefile.
+func (mf *GnobMakefile) optionSet() (*flag.FlagSet, *GnobmakeOptions) {
+	opts := &GnobmakeOptions{jobs: mf.Jobs()}
+	fs := flag.NewFlagSet(mf.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&opts.help, "help", false, "show the targets, or the help of the given target")
+	fs.BoolVar(&opts.help, "h", false, "shorthand for -help")
+	fs.BoolVar(&opts.verbose, "v", false, "show debug messages")
+	fs.BoolVar(&opts.quiet, "q", false, "only show warnings and errors")
+	fs.StringVar(&opts.dir, "C", "", "change to `dir` before running the targets")
+	fs.IntVar(&opts.jobs, "j", opts.jobs, "maximum number of targets executed at the same time")
+	fs.BoolVar(&opts.parallel, "parallel", false, "execute the targets given on the command line concurrently")
+	fs.BoolVar(&opts.dryRun, "n", false, "print the targets that would run, without executing them")
+	fs.BoolVar(&opts.keepGoing, "k", false, "keep going after a target fails, skipping only the targets that depend on it")
+	fs.StringVar(&opts.summary, "summary-json", "", "write the summary of the run as JSON to `file`")
+	fs.StringVar(&opts.trace, "trace", "", "write a Chrome trace of the targets and commands to `file`, to be loaded in Perfetto")
+	fs.Var(&opts.list, "list", "list the targets, one per line, or as JSON with -list=json")
+	fs.Var(&opts.graph, "graph", "show the dependency graph as DOT with -graph=dot, or as JSON with -graph=json, after executing the given targets")
+	fs.BoolVar(&opts.watch, "watch", false, "execute the targets again each time the files they are built from change")
+	fs.StringVar(&opts.vars, "vars", "", "read the variables from `file`, with KEY=value lines or a JSON object, instead of gnob.env or gnob.json")
+	fs.StringVar(&opts.genDocs, "gen-docs", "", "print the documentation of the targets as markdown, man, or with the template `file`")
+	fs.StringVar(&opts.completion, "completion", "", "print the completion script for `shell`, which is bash, zsh, or fish")
+	return fs, opts
+}
+
+// parseOptions parses the global flags from the beginning of the argument list, and returns the remaining arguments.
+// Both `-flag` and `--flag` are accepted.
+func (mf *GnobMakefile) parseOptions(args []string) (*GnobmakeOptions, []string, error) {
+	fs, opts := mf.optionSet()
+	if err := fs.Parse(GnobexpandJobs(fs, args)); err != nil {
+		return nil, nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "j" {
+			mf.SetJobs(opts.jobs)
+		}
+	})
+	mf.parallel = opts.parallel
+	mf.dryRun = opts.dryRun
+	mf.keepGoing = opts.keepGoing
+	mf.summaryFile = opts.summary
+	if opts.trace != "" {
+		Gnobtraces.enable(opts.trace)
+	}
+	switch {
+	case opts.verbose:
+		GnobSetLogLevel(slog.LevelDebug)
+	case opts.quiet:
+		GnobSetLogLevel(slog.LevelWarn)
+	}
+	if opts.graph == "text" {
+		opts.graph = "dot"
+	}
+	return opts, fs.Args(), nil
+}
+
+// expandJobs rewrites the "-jN" shorthand of make to "-j=N" in the global flags of the argument list.
+// The values of the flags of fs that take one, like `-C dir`, are skipped.
+func GnobexpandJobs(fs *flag.FlagSet, args []string) []string {
+	args = slices.Clone(args)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "--" {
+			break
+		}
+		if n := strings.TrimPrefix(arg, "-j"); n != arg && n != "" && n[0] != '=' {
+			args[i] = "-j=" + n
+		} else if GnobtakesValue(fs, arg) {
+			i++
+		}
+	}
+	return args
+}
+
+// takesValue returns true if the word is a flag of fs that takes its value from the next word,
+// like `-C dir`, but not `-C=dir` or a boolean flag.
+func GnobtakesValue(fs *flag.FlagSet, word string) bool {
+	name, _, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+	f := fs.Lookup(name)
+	if f == nil || hasValue {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// makeListEntry is a target listed by `gnob -list=json`.
+type GnobmakeListEntry struct {
+	Name    string   `json:"name"`
+	Desc    string   `json:"desc,omitempty"`
+	Default bool     `json:"default,omitempty"`
+	Pattern bool     `json:"pattern,omitempty"`
+	Deps    []string `json:"deps,omitempty"`
+	Flags   []string `json:"flags,omitempty"`
+}
+
+// showList prints the targets that are not hidden in the given format.
+// The text format has one target per line, followed by a tab and its description.
+func (mf *GnobMakefile) showList(format GnobformatValue) error {
+	flagNames := func(flags []GnobTargetFlag) []string {
+		var names []string
+		for _, f := range flags {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+	var entries []GnobmakeListEntry
+	for i, tgt := range mf.targets {
+		if tgt.Hidden {
+			continue
+		}
+		entries = append(entries, GnobmakeListEntry{
+			Name:    tgt.Name,
+			Desc:    tgt.Desc,
+			Default: i == mf.defaultTarget,
+			Deps:    tgt.Deps,
+			Flags:   flagNames(tgt.Flags),
+		})
+	}
+	for _, pt := range mf.patterns {
+		if pt.Hidden {
+			continue
+		}
+		entries = append(entries, GnobmakeListEntry{
+			Name:    pt.Name,
+			Desc:    pt.Desc,
+			Pattern: true,
+			Deps:    pt.Deps,
+			Flags:   flagNames(pt.Flags),
+		})
+	}
+	switch format {
+	case "text":
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\n", e.Name, e.Desc)
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	default:
+		return fmt.Errorf("unknown list format: %s", format)
+	}
+}
+
+// PatternTarget is a rule for targets whose names match a pattern, like the `%.o: %.c` rules of Make.
+// The Name is a pattern containing a single '%', which matches any non-empty stem.
+// When Makefile.Find is called with a name that no other target has, and that matches the pattern,
+// a MakeTarget is created on demand from the PatternTarget, with every '%' in its Deps, Inputs, and Outputs
+// replaced by the stem.
+// If several patterns match a name, the one with the shortest stem is used.
+type GnobPatternTarget struct {
+	// Name is the pattern of the names of the targets, like `examples/%/gnob`.
+	Name string
+	// Desc is a short description of the targets.
+	// It is shown when listing all targets with `gnob -help`.
+	Desc string
+	// LongDesc is a long description of the targets.
+	// It is shown when running `gnob -help <target>`.
+	LongDesc string
+	// Hidden is true if the pattern should be hidden from listing.
+	Hidden bool
+	// Flags are the flags the targets accept on the command line.
+	Flags []GnobTargetFlag
+	// Deps are the names of the targets the targets depend on. They may contain '%'.
+	Deps []string
+	// Inputs are the files the targets are built from. They may contain '%' and be glob patterns.
+	Inputs []string
+	// Outputs are the files the targets produce. They may contain '%' and be glob patterns.
+	Outputs []string
+	// UpToDate is a function that returns true if the target with the given stem is up-to-date.
+	UpToDate func(mf *GnobMakefile, stem string) bool
+	// Body is the function that executes the target with the given stem.
+	Body func(ctx context.Context, mf *GnobMakefile, stem string) error
+	// Timeout is the maximum time each execution of the Body may take.
+	Timeout time.Duration
+	// Retries is the number of times the Body is executed again after it fails or times out.
+	Retries int
+	// RetryBackoff is the delay before the first retry, which doubles after each retry.
+	RetryBackoff time.Duration
+	// EchoCommands is true if the Body only has side effects through the commands it executes with Cmd.Exec.
+	EchoCommands bool
+	// namespace is the prefix under which the pattern target was mounted with Makefile.Mount, if any.
+	namespace string
+}
+
+// AddPattern adds pattern targets to the Makefile.
+func (mf *GnobMakefile) AddPattern(pts ...GnobPatternTarget) {
+	for _, pt := range pts {
+		if strings.Count(pt.Name, "%") != 1 {
+			GnobLogger.Warn("[gnob] pattern target must contain a single '%'", "name", pt.Name)
+			continue
+		}
+		mf.patterns = append(mf.patterns, &pt)
+	}
+	mf.patternsMu.Lock()
+	mf.instances = nil
+	mf.patternsMu.Unlock()
+	mf.normalize()
+}
+
+// match returns the stem if the name matches the pattern of the target.
+func (pt *GnobPatternTarget) match(name string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(pt.Name, "%")
+	if len(name) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+	if !strings.EqualFold(name[:len(prefix)], prefix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// instance creates the MakeTarget with the given name and stem from the pattern target.
+func (pt *GnobPatternTarget) instance(name string, stem string) *GnobMakeTarget {
+	expand := func(patterns []string) []string {
+		if patterns == nil {
+			return nil
+		}
+		expanded := make([]string, 0, len(patterns))
+		for _, p := range patterns {
+			expanded = append(expanded, strings.ReplaceAll(p, "%", stem))
+		}
+		return expanded
+	}
+	tgt := &GnobMakeTarget{
+		Name:         name,
+		Desc:         pt.Desc,
+		LongDesc:     pt.LongDesc,
+		Hidden:       pt.Hidden,
+		Flags:        pt.Flags,
+		Deps:         expand(pt.Deps),
+		Inputs:       expand(pt.Inputs),
+		Outputs:      expand(pt.Outputs),
+		Timeout:      pt.Timeout,
+		Retries:      pt.Retries,
+		RetryBackoff: pt.RetryBackoff,
+		EchoCommands: pt.EchoCommands,
+		namespace:    pt.namespace,
+		Body: func(ctx context.Context, mf *GnobMakefile) error {
+			return pt.Body(ctx, mf, stem)
+		},
+	}
+	if pt.UpToDate != nil {
+		tgt.UpToDate = func(mf *GnobMakefile) bool {
+			return pt.UpToDate(mf, stem)
+		}
+	}
+	return tgt
+}
+
+// findPattern returns the target created from the pattern target that matches the name with the shortest stem.
+// Targets are created once per name, so that they are executed at most once per run.
+// If no pattern target matches, it returns nil.
+func (mf *GnobMakefile) findPattern(name string) *GnobMakeTarget {
+	var (
+		found *GnobPatternTarget
+		stem  string
+	)
+	for _, pt := range mf.patterns {
+		if s, ok := pt.match(name); ok && (found == nil || len(s) < len(stem)) {
+			found, stem = pt, s
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	key := strings.ToLower(name)
+	mf.patternsMu.Lock()
+	defer mf.patternsMu.Unlock()
+	if tgt, ok := mf.instances[key]; ok {
+		return tgt
+	}
+	if mf.instances == nil {
+		mf.instances = make(map[string]*GnobMakeTarget)
+	}
+	tgt := found.instance(name, stem)
+	mf.instances[key] = tgt
+	GnobLogger.Debug("[gnob:makefile] pattern target matched", "target", name, "pattern", found.Name, "stem", stem)
+	return tgt
+}
+
+// patternInstances returns the targets created from pattern targets so far, sorted by name.
+func (mf *GnobMakefile) patternInstances() []*GnobMakeTarget {
+	mf.patternsMu.Lock()
+	defer mf.patternsMu.Unlock()
+	return slices.SortedFunc(maps.Values(mf.instances), func(a, b *GnobMakeTarget) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// isTerminal returns true if the file is a terminal.
+func GnobisTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// visibleTargets returns the targets that are not hidden.
+func (mf *GnobMakefile) visibleTargets() []*GnobMakeTarget {
+	var targets []*GnobMakeTarget
+	for _, tgt := range mf.targets {
+		if !tgt.Hidden {
+			targets = append(targets, tgt)
+		}
+	}
+	return targets
+}
+
+// pickTarget returns the target to execute when none is given on the command line, and no target is the default.
+// On a terminal, it shows a menu of the targets that are not hidden, from which the user picks one by number,
+// or by typing part of its name or description to filter the menu.
+// Otherwise, it returns an error listing the targets, instead of guessing.
+func (mf *GnobMakefile) pickTarget() (*GnobMakeTarget, error) {
+	targets := mf.visibleTargets()
+	if len(targets) == 0 {
+		return nil, errors.New("no target to execute")
+	}
+	if !GnobisTerminal(os.Stdin) || !GnobisTerminal(os.Stdout) {
+		var sb strings.Builder
+		sb.WriteString("no target given, and no default target; run one of:")
+		GnobwriteTargetMenu(&sb, targets, false)
+		return nil, errors.New(sb.String())
+	}
+	return GnobpickTargetFrom(os.Stdin, os.Stdout, targets)
+}
+
+// pickTargetFrom shows the menu of targets on w, and reads the choice of the user from r.
+func GnobpickTargetFrom(r io.Reader, w io.Writer, targets []*GnobMakeTarget) (*GnobMakeTarget, error) {
+	in := bufio.NewReader(r)
+	matches := targets
+	for {
+		GnobwriteTargetMenu(w, matches, true)
+		_, _ = fmt.Fprint(w, "\nSelect a target by number, or type to filter: ")
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			_, _ = fmt.Fprintln(w)
+			return nil, errors.New("no target selected")
+		}
+		line = strings.TrimSpace(line)
+		if n, err := strconv.Atoi(line); err == nil {
+			if n >= 1 && n <= len(matches) {
+				return matches[n-1], nil
+			}
+			_, _ = fmt.Fprintf(w, "no target numbered %d\n", n)
+			continue
+		}
+		if line == "" {
+			if len(matches) == 1 {
+				return matches[0], nil
+			}
+			matches = targets
+			continue
+		}
+		filtered := GnobfilterTargets(targets, line)
+		switch len(filtered) {
+		case 0:
+			_, _ = fmt.Fprintf(w, "no target matches %q\n", line)
+			matches = targets
+		case 1:
+			return filtered[0], nil
+		default:
+			matches = filtered
+		}
+	}
+}
+
+// filterTargets returns the targets whose name or description contains the filter, ignoring case.
+func GnobfilterTargets(targets []*GnobMakeTarget, filter string) []*GnobMakeTarget {
+	filter = strings.ToLower(filter)
+	var matches []*GnobMakeTarget
+	for _, tgt := range targets {
+		if strings.Contains(strings.ToLower(tgt.Name), filter) || strings.Contains(strings.ToLower(tgt.Desc), filter) {
+			matches = append(matches, tgt)
+		}
+	}
+	return matches
+}
+
+// writeTargetMenu writes the targets with their description, one per line, numbered if numbered is true.
+func GnobwriteTargetMenu(w io.Writer, targets []*GnobMakeTarget, numbered bool) {
+	maxLen := 0
+	for _, tgt := range targets {
+		maxLen = max(maxLen, len(tgt.Name))
+	}
+	for i, tgt := range targets {
+		if numbered {
+			_, _ = fmt.Fprintf(w, "\n%3d) %-*s   %s", i+1, maxLen, tgt.Name, tgt.Desc)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "\n  %-*s   %s", maxLen, tgt.Name, tgt.Desc)
+	}
+}
+
+// Project is another gnob project in a directory of its own, like the `build/` directory of a component in a monorepo.
+// Its targets can be executed with Run, or imported into a Makefile with Makefile.Import.
+type GnobProject struct {
+	// Dir is the directory of the project.
+	Dir string
+	// Sources are the source files of the gnob binary of the project, relative to Dir.
+	Sources []string
+
+	mu sync.Mutex
+}
+
+// Project returns the gnob project in the given directory.
+// The gnob binary of the project is built from the given sources, which default to `*.go`.
+func (Gnob_makefile) Project(dir string, sources ...string) *GnobProject {
+	if len(sources) == 0 {
+		sources = []string{"*.go"}
+	}
+	return &GnobProject{Dir: dir, Sources: sources}
+}
+
+// Build builds the gnob binary of the project if any of its sources is newer than the binary,
+// like RebuildYourself does for the running binary.
+func (p *GnobProject) Build(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var f Gnob_files
+	binary := filepath.Join(p.Dir, GnobbinaryName())
+	sources := make([]string, 0, len(p.Sources))
+	for _, s := range p.Sources {
+		sources = append(sources, filepath.Join(p.Dir, s))
+	}
+	if !f.TargetNeedsUpdate(binary, sources...) {
+		GnobLogger.DebugContext(ctx, "[gnob:project] gnob is up to date", "dir", p.Dir)
+		return nil
+	}
+	GnobLogger.DebugContext(ctx, "[gnob:project] building gnob", "dir", p.Dir)
+	var c Gnob_cmd
+	var stderr bytes.Buffer
+	err := c.ExecOpt(ctx, c.ExecOptions(c.WithDir(p.Dir), c.WithStdout(os.Stdout), c.WithStderr(&stderr)),
+		GnobGoCommand, "build", "-tags", GnobbuildTags(), "-o", GnobbinaryName(), ".").Run()
+	if err != nil {
+		return fmt.Errorf("failed to build gnob in %s: %w\n%s", p.Dir, err, stderr.String())
+	}
+	return nil
+}
+
+// Exec creates a command that executes the gnob binary of the project with the given arguments, in its directory.
+// The standard output and error of the command are those of the current process.
+// It does not build the binary, see Run.
+func (p *GnobProject) Exec(ctx context.Context, args ...string) *GnobExec {
+	return p.ExecOpt(ctx, nil, args...)
+}
+
+// ExecOpt is like Exec, but you can specify options to customize the command.
+func (p *GnobProject) ExecOpt(ctx context.Context, opt GnobExecOption, args ...string) *GnobExec {
+	var c Gnob_cmd
+	opts := c.ExecOptions(c.WithDir(p.Dir), c.WithStdout(os.Stdout), c.WithStderr(os.Stderr))
+	if opt != nil {
+		opts = c.ExecOptions(opts, opt)
+	}
+	return c.ExecOpt(ctx, opts, "."+string(filepath.Separator)+GnobbinaryName(), args...)
+}
+
+// Run builds the gnob binary of the project if needed, and executes it with the given arguments,
+// like `gnob test` in the directory of the project.
+func (p *GnobProject) Run(ctx context.Context, args ...string) error {
+	if err := p.Build(ctx); err != nil {
+		return err
+	}
+	return p.Exec(ctx, args...).Run()
+}
+
+// targets returns the targets of the project, as listed by `gnob -list=json`.
+func (p *GnobProject) targets(ctx context.Context) ([]GnobmakeListEntry, error) {
+	if err := p.Build(ctx); err != nil {
+		return nil, err
+	}
+	var (
+		c      Gnob_cmd
+		stdout bytes.Buffer
+	)
+	if err := p.ExecOpt(ctx, c.WithStdout(&stdout), "-list=json").Run(); err != nil {
+		return nil, fmt.Errorf("unable to list the targets of %s: %w", p.Dir, err)
+	}
+	var entries []GnobmakeListEntry
+	if err := json.Unmarshal(stdout.Bytes(), &entries); err != nil {
+		return nil, fmt.Errorf("unable to decode the targets of %s: %w", p.Dir, err)
+	}
+	return entries, nil
+}
+
+// Import adds the targets of the project under the given namespace, so that `gnob -help` lists them,
+// and running the `prefix:test` target runs `gnob test` in the directory of the project.
+// The arguments of the imported targets are passed to the gnob binary of the project; use `--` to pass flags,
+// like `gnob prefix:build -- -race`.
+// The gnob binary of the project is built if needed to list its targets.
+func (mf *GnobMakefile) Import(ctx context.Context, prefix string, p *GnobProject) error {
+	entries, err := p.targets(ctx)
+	if err != nil {
+		return err
+	}
+	var (
+		targets  []GnobMakeTarget
+		patterns []GnobPatternTarget
+	)
+	for _, e := range entries {
+		if e.Pattern {
+			patterns = append(patterns, GnobPatternTarget{
+				Name:         prefix + ":" + e.Name,
+				Desc:         e.Desc,
+				EchoCommands: true,
+				Body: func(ctx context.Context, mf *GnobMakefile, stem string) error {
+					return p.Run(ctx, append([]string{strings.Replace(e.Name, "%", stem, 1)}, mf.TargetArgs()...)...)
+				},
+			})
+			continue
+		}
+		targets = append(targets, GnobMakeTarget{
+			Name:         prefix + ":" + e.Name,
+			Desc:         e.Desc,
+			EchoCommands: true,
+			Body: func(ctx context.Context, mf *GnobMakefile) error {
+				return p.Run(ctx, append([]string{e.Name}, mf.TargetArgs()...)...)
+			},
+		})
+	}
+	mf.Add(targets...)
+	if len(patterns) > 0 {
+		mf.AddPattern(patterns...)
+	}
+	return nil
+}
+
 const (
 	GnobEnvRebuildDisable = "GNOB_REBUILD_DISABLE"
 	GnobEnvLogLevel       = "GNOB_LOG_LEVEL"
@@ -917,12 +2687,7 @@ var (
 
 func (r Gnob_root) GoRebuildYourself(sources ...string) {
 	if GnobBinaryName == "" {
-		switch runtime.GOOS {
-		case "windows":
-			GnobBinaryName = "gnob.exe"
-		default:
-			GnobBinaryName = "gnob"
-		}
+		GnobBinaryName = GnobbinaryName()
 	}
 	if err := r.RebuildYourself(context.Background(), sources...); err != nil {
 		GnobLogger.Error("[gnob:rebuild] failed to rebuild", "error", err)
@@ -937,7 +2702,36 @@ func (r Gnob_root) GoRebuildYourself(sources ...string) {
 type Gnob_root struct {
 }
 
+// binaryName returns the BinaryName, which defaults to gnob, or gnob.exe on Windows.
+func GnobbinaryName() string {
+	if GnobBinaryName != "" {
+		return GnobBinaryName
+	}
+	if runtime.GOOS == "windows" {
+		return "gnob.exe"
+	}
+	return "gnob"
+}
+
+// buildTags returns the build tags the running binary was built with, which default to gnob.
+func GnobbuildTags() string {
+	if rbi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range rbi.Settings {
+			if s.Key == "-tags" {
+				return s.Value
+			}
+		}
+	}
+	return "gnob"
+}
+
+// RebuildYourself rebuilds the gnob binary from the sources if any of them is newer than the binary,
+// and executes the new binary with the same arguments.
+// If a trace file is given with `-trace <file>`, the rebuild is added to the trace written by the new binary.
 func (r Gnob_root) RebuildYourself(ctx context.Context, sources ...string) error {
+	if file := GnobtraceFlag(os.Args[1:]); file != "" {
+		Gnobtraces.enable(file)
+	}
 	if os.Getenv(GnobEnvRebuildDisable) != "" {
 		GnobLogger.DebugContext(ctx, "[gnob:rebuild] rebuild disabled")
 		return nil
@@ -962,6 +2756,7 @@ func (r Gnob_root) RebuildYourself(ctx context.Context, sources ...string) error
 		if err = r.runBinary(ctx, GnobBinaryName); err != nil {
 			return fmt.Errorf("failed to run %s: %v", GnobBinaryName, err)
 		}
+		r.writeTrace()
 		os.Exit(0)
 	}
 	sources, err = r.normalizeSources(binary, sources)
@@ -978,6 +2773,7 @@ func (r Gnob_root) RebuildYourself(ctx context.Context, sources ...string) error
 		if err = r.runBinary(ctx, binary); err != nil {
 			return fmt.Errorf("failed to run %s: %v", binary, err)
 		}
+		r.writeTrace()
 		os.Exit(0)
 	}
 	GnobLogger.DebugContext(ctx, "[gnob:rebuild] gnob is up to date")
@@ -1024,21 +2820,21 @@ func (r Gnob_root) runBinary(ctx context.Context, binary string) error {
 	return cmd.Run()
 }
 
-func (r Gnob_root) rebuild(ctx context.Context, binary string, sources []string) error {
+// writeTrace adds the spans of this process to the trace written by the binary it executed.
+func (r Gnob_root) writeTrace() {
+	if err := Gnobtraces.write(true); err != nil {
+		GnobLogger.Warn("[gnob:rebuild] unable to write trace", "error", err)
+	}
+}
+
+func (r Gnob_root) rebuild(ctx context.Context, binary string, sources []string) (err error) {
+	span := Gnobtraces.start("gnob", "rebuild", map[string]any{"binary": binary})
+	defer func() { span.end(err) }()
 	GnobLogger.DebugContext(ctx, "[gnob:rebuild] rebuilding", "binary", binary)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	tags := "gnob"
-	if rbi, ok := debug.ReadBuildInfo(); ok {
-		for _, s := range rbi.Settings {
-			if s.Key == "-tags" {
-				tags = s.Value
-				break
-			}
-		}
-	}
 	args := []string{"build",
-		"-tags", tags,
+		"-tags", GnobbuildTags(),
 		"-o", binary,
 	}
 	var stderr bytes.Buffer
@@ -1047,7 +2843,7 @@ func (r Gnob_root) rebuild(ctx context.Context, binary string, sources []string)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 	cmd.Stdin = os.Stdin
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return err
 	}
@@ -1075,7 +2871,345 @@ func (r Gnob_root) forwardSignals(ctx context.Context, cmd *exec.Cmd) {
 	}
 }
 
-type Gnob_makefile struct {
+// StateDir is the directory where gnob stores the state it keeps between runs.
+// It is relative to the working directory, and should be ignored by version control.
+var GnobStateDir = ".gnob"
+
+const (
+	GnobhashStateFile   = "hashes.json"
+	GnobtargetStateFile = "state"
+)
+
+// Results of a target recorded in a TargetState.
+// TargetSkipped and TargetUpToDate are only reported in the summary of a run, since the body is not executed.
+const (
+	GnobTargetSucceeded = "success"
+	GnobTargetFailed    = "failure"
+	GnobTargetTimedOut  = "timeout"
+	GnobTargetSkipped   = "skipped"
+	GnobTargetUpToDate  = "up-to-date"
+)
+
+// TargetState is the state of a target recorded in the state database when its body was last executed.
+type GnobTargetState struct {
+	// LastRun is the time the body of the target was last executed.
+	LastRun time.Time `json:"lastRun"`
+	// Duration is how long the body of the target took to execute.
+	Duration time.Duration `json:"duration"`
+	// Inputs are the SHA-256 digests of the inputs of the target, by path.
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// Outputs are the outputs declared by the target.
+	Outputs []string `json:"outputs,omitempty"`
+	// Result is either TargetSucceeded, TargetFailed, or TargetTimedOut.
+	Result string `json:"result"`
+	// Error is the error message if the target failed.
+	Error string `json:"error,omitempty"`
+}
+
+// stateMu serializes updates to the files in the StateDir.
+var GnobstateMu sync.Mutex
+
+// hashRecord is the state recorded by HashUpToDate after a target is built.
+type GnobhashRecord struct {
+	// Target is the digest of the target file.
+	Target string `json:"target"`
+	// Sources are the digests of the source files, by path.
+	Sources map[string]string `json:"sources"`
+}
+
+// loadState decodes the JSON state file with the given name from the StateDir into v.
+// If the file does not exist, v is left unchanged.
+func GnobloadState(name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(GnobStateDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read state file %q: %w", name, err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to decode state file %q: %w", name, err)
+	}
+	return nil
+}
+
+// saveState encodes v as JSON into the state file with the given name in the StateDir.
+// The file is replaced atomically, so concurrent readers never see a partial file.
+func GnobsaveState(name string, v any) error {
+	if err := os.MkdirAll(GnobStateDir, 0o755); err != nil {
+		return fmt.Errorf("unable to create state directory %q: %w", GnobStateDir, err)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode state file %q: %w", name, err)
+	}
+	tmp, err := os.CreateTemp(GnobStateDir, name+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create state file %q: %w", name, err)
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write state file %q: %w", name, err)
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to close state file %q: %w", name, err)
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(GnobStateDir, name)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to replace state file %q: %w", name, err)
+	}
+	return nil
+}
+
+// updateState loads the state file with the given name, passes it to fn to be modified, and saves it again.
+func GnobupdateState[T any](name string, fn func(state map[string]T)) error {
+	GnobstateMu.Lock()
+	defer GnobstateMu.Unlock()
+	state := make(map[string]T)
+	if err := GnobloadState(name, &state); err != nil {
+		return err
+	}
+	fn(state)
+	return GnobsaveState(name, state)
+}
+
+// RunSummary is the summary of the targets executed during a run of the Makefile.
+type GnobRunSummary struct {
+	// Targets are the executed targets, in the order they finished.
+	Targets []GnobTargetSummary `json:"targets"`
+	// CriticalPath is the chain of dependencies that determined how long the run took,
+	// from the first dependency on the chain to the target that finished last.
+	CriticalPath []string `json:"criticalPath,omitempty"`
+	// Duration is the time between the start of the first target and the end of the last target.
+	Duration time.Duration `json:"duration"`
+}
+
+// TargetSummary is the summary of a target executed during a run of the Makefile.
+type GnobTargetSummary struct {
+	// Name is the name of the target.
+	Name string `json:"name"`
+	// Result is one of TargetSucceeded, TargetFailed, TargetTimedOut, TargetSkipped, or TargetUpToDate.
+	Result string `json:"result"`
+	// Attempts is the number of times the Body was executed, which is more than one if it was retried.
+	Attempts int `json:"attempts,omitempty"`
+	// Start is when the target started, after its declared dependencies finished.
+	// It is zero for skipped targets.
+	Start time.Time `json:"start,omitzero"`
+	// Duration is the wall time of the target, including the dependencies it takes with Depend.
+	Duration time.Duration `json:"duration"`
+	// Error is the error message if the target failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Summary returns the summary of the targets executed during the last run of the Makefile.
+func (mf *GnobMakefile) Summary() *GnobRunSummary {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	s := &GnobRunSummary{Targets: make([]GnobTargetSummary, 0, len(mf.finished))}
+	var first, last time.Time
+	var lastTarget *GnobMakeTarget
+	for _, tgt := range mf.finished {
+		run := mf.runs[tgt]
+		ts := GnobTargetSummary{Name: tgt.Name, Result: run.result, Attempts: run.attempts, Start: run.start}
+		if !run.start.IsZero() {
+			ts.Duration = run.end.Sub(run.start)
+			if first.IsZero() || run.start.Before(first) {
+				first = run.start
+			}
+		}
+		if (run.result == GnobTargetFailed || run.result == GnobTargetTimedOut) && run.err != nil {
+			ts.Error = run.err.Error()
+		}
+		if lastTarget == nil || run.end.After(last) {
+			last, lastTarget = run.end, tgt
+		}
+		s.Targets = append(s.Targets, ts)
+	}
+	if !first.IsZero() {
+		s.Duration = last.Sub(first)
+	}
+	s.CriticalPath = mf.criticalPath(lastTarget)
+	return s
+}
+
+// criticalPath follows the dependencies of the target that finished last,
+// each time picking the dependency that finished last.
+// The caller must hold runsMu.
+func (mf *GnobMakefile) criticalPath(tgt *GnobMakeTarget) []string {
+	var path []string
+	for tgt != nil {
+		path = append(path, tgt.Name)
+		var next *GnobMakeTarget
+		for e := range mf.edges {
+			if e.from != tgt {
+				continue
+			}
+			run, ok := mf.runs[e.to]
+			if !ok || run.end.IsZero() {
+				continue
+			}
+			if next == nil || run.end.After(mf.runs[next].end) {
+				next = e.to
+			}
+		}
+		tgt = next
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// WriteJSON writes the summary as JSON to w.
+func (s *GnobRunSummary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteTable writes the summary as a table to w, followed by the critical path.
+func (s *GnobRunSummary) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tRESULT\tTIME")
+	for _, t := range s.Targets {
+		result := t.Result
+		if t.Attempts > 1 {
+			result += fmt.Sprintf(" (%d attempts)", t.Attempts)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, result, t.Duration.Round(time.Millisecond))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(s.CriticalPath) > 0 {
+		_, err := fmt.Fprintf(w, "Critical path: %s (%s)\n", strings.Join(s.CriticalPath, " -> "), s.Duration.Round(time.Millisecond))
+		return err
+	}
+	return nil
+}
+
+// showSummary prints the summary of the run to stderr, and writes it as JSON to the file given with -summary-json.
+// Nothing is printed if no target was executed, or in dry-run mode.
+func (mf *GnobMakefile) showSummary() {
+	mf.runsMu.Lock()
+	n := len(mf.finished)
+	mf.runsMu.Unlock()
+	if n == 0 || mf.dryRun {
+		return
+	}
+	s := mf.Summary()
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err := s.WriteTable(os.Stderr); err != nil {
+		GnobLogger.Warn("[gnob:makefile] unable to print summary", "error", err)
+	}
+	if mf.summaryFile == "" {
+		return
+	}
+	f, err := os.Create(mf.summaryFile)
+	if err == nil {
+		err = errors.Join(s.WriteJSON(f), f.Close())
+	}
+	if err != nil {
+		GnobLogger.Warn("[gnob:makefile] unable to write summary", "file", mf.summaryFile, "error", err)
+	}
+}
+
+// keepGoingResult returns the errors of the targets that failed in keep-going mode.
+// Targets that were skipped because a dependency failed do not add to the error.
+// If no target failed, err is returned.
+func (mf *GnobMakefile) keepGoingResult(err error) error {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	var errs []error
+	for _, tgt := range mf.finished {
+		if run := mf.runs[tgt]; run.result == GnobTargetFailed || run.result == GnobTargetTimedOut {
+			errs = append(errs, fmt.Errorf("target %s: %w", tgt.Name, run.err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return err
+}
+
+type Gnob_makefile struct {
+}
+
+// ErrTargetTimeout is the error of a target whose Body did not finish within its Timeout.
+var GnobErrTargetTimeout = errors.New("target timed out")
+
+// StateUpToDate returns a function that returns true if the target is up-to-date.
+// The target is up-to-date if its last execution recorded in the state database succeeded,
+// the SHA-256 digests of the inputs have not changed since, and every output exists.
+// Inputs and outputs may be glob patterns.
+// This is useful for targets that do not produce a single file, like running tests.
+// The result of the execution is recorded in the state database when the body of the target finishes.
+// This can be used as the UpToDate function of a MakeTarget.
+func (Gnob_makefile) StateUpToDate(inputs []string, outputs []string) func(*GnobMakefile) bool {
+	var f Gnob_files
+	return func(mf *GnobMakefile) bool {
+		mf.watchFiles(inputs...)
+		digests, err := f.Digests(inputs...)
+		if err != nil {
+			GnobLogger.Warn("[gnob:makefile] unable to compute digests", "error", err)
+			return false
+		}
+		if mf.run != nil {
+			mf.run.state = &GnobTargetState{Inputs: digests, Outputs: outputs}
+		}
+		if mf.target == nil {
+			return false
+		}
+		state, ok := mf.State(mf.target.Name)
+		if !ok || state.Result != GnobTargetSucceeded || !maps.Equal(state.Inputs, digests) {
+			return false
+		}
+		for _, output := range outputs {
+			if matches, _ := filepath.Glob(output); len(matches) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// HashUpToDate returns a function that returns true if the target is up-to-date.
+// The target is up-to-date if neither the target nor the sources have changed since the target was last built,
+// according to the SHA-256 digests of their contents.
+// Unlike FileUpToDate, it does not rely on modification times, so it is not affected by
+// checking out files, restoring caches, or copying files.
+// The digests are recorded in the StateDir after the body of the target succeeds.
+// This can be used as the UpToDate function of a MakeTarget.
+func (Gnob_makefile) HashUpToDate(target string, sources ...string) func(*GnobMakefile) bool {
+	var f Gnob_files
+	return func(mf *GnobMakefile) bool {
+		mf.watchFiles(sources...)
+		digests, err := f.Digests(sources...)
+		if err != nil {
+			GnobLogger.Warn("[gnob:makefile] unable to compute digests", "target", target, "error", err)
+			return false
+		}
+		var state map[string]GnobhashRecord
+		if err = GnobloadState(GnobhashStateFile, &state); err != nil {
+			GnobLogger.Warn("[gnob:makefile] unable to load hash state", "error", err)
+		}
+		rec, ok := state[target]
+		if ok && f.Exists(target) && maps.Equal(rec.Sources, digests) {
+			if digest, err := f.Digest(target); err == nil && digest == rec.Target {
+				return true
+			}
+		}
+		mf.afterSuccess(func() error {
+			digest, err := f.Digest(target)
+			if err != nil {
+				return fmt.Errorf("unable to record digest of %q: %w", target, err)
+			}
+			return GnobupdateState(GnobhashStateFile, func(state map[string]GnobhashRecord) {
+				state[target] = GnobhashRecord{Target: digest, Sources: digests}
+			})
+		})
+		return false
+	}
 }
 
 // FileUpToDate returns a function that returns true if the target is up-to-date.
@@ -1084,6 +3218,7 @@ type Gnob_makefile struct {
 func (Gnob_makefile) FileUpToDate(target string, sources ...string) func(*GnobMakefile) bool {
 	var f Gnob_files
 	return func(mf *GnobMakefile) bool {
+		mf.watchFiles(sources...)
 		return !f.TargetNeedsUpdate(target, sources...)
 	}
 }
@@ -1091,14 +3226,78 @@ func (Gnob_makefile) FileUpToDate(target string, sources ...string) func(*GnobMa
 // Makefile is a collection of targets.
 // This can be used as a main function to make gnob behave like a Makefile.
 type GnobMakefile struct {
-	name          string
-	args          []string
-	commandArgs   []string
-	targets       []*GnobMakeTarget
+	*GnobmakefileState
+	// target is the target that is being executed with this Makefile, and run is its result.
+	// Both are nil for the Makefile returned by New and NewEx.
+	target *GnobMakeTarget
+	run    *GnobtargetRun
+}
+
+// makefileState is shared between a Makefile and the copies of it that are passed to each target.
+type GnobmakefileState struct {
+	name        string
+	args        []string
+	commandArgs []string
+	targets     []*GnobMakeTarget
+	patterns    []*GnobPatternTarget
+	patternsMu  sync.Mutex
+	instances   map[string]*GnobMakeTarget
+	// defaultTarget is the index of the target with Default set, or -1 if there is none.
 	defaultTarget int
-	ctx           context.Context
+	jobs          int
+	// jobSlots limits the number of targets executed at the same time by all the calls to DependParallel.
+	jobSlots    chan struct{}
+	parallel    bool
+	dryRun      bool
+	keepGoing   bool
+	hooks       GnobmakeHooks
+	summaryFile string
+	// finished are the targets that finished executing during the run, in order.
+	finished     []*GnobMakeTarget
+	runsMu       sync.Mutex
+	runs         map[*GnobMakeTarget]*GnobtargetRun
+	commandLines map[*GnobMakeTarget]GnobcommandLine
+	edges        map[GnobmakeEdge]struct{}
+	depsErr      error
+	// vars are the declared variables, sorted by name, and cmdVars and fileVars are the values of the variables
+	// given on the command line and in the variables file.
+	vars     []GnobMakeVar
+	cmdVars  map[string]string
+	fileVars map[string]string
+	// duplicates are the names of the targets that were discarded because another target has the same name.
+	duplicates []string
+	ctx        context.Context
+}
+
+// targetRun is the result of executing a target once during a run of the Makefile.
+// done is closed when the target has finished executing.
+type GnobtargetRun struct {
+	done chan struct{}
+	err  error
+	// result is one of TargetSucceeded, TargetFailed, TargetSkipped, or TargetUpToDate once the target has finished.
+	result string
+	// start is when the dependencies of the target finished, and end is when the target finished.
+	start, end time.Time
+	// attempts is the number of times the Body was executed.
+	attempts  int
+	onSuccess []func() error
+	// cmd is the flags and arguments of the target, parsed from the command line if the target was given on it.
+	cmd GnobcommandLine
+	// state is recorded in the state database when the body of the target finishes.
+	// It is nil unless the UpToDate function of the target uses the state database.
+	state *GnobTargetState
+	// watched are the files given to the up-to-date functions of the target, which are watched in watch mode.
+	watched []string
+	// waiting counts the running targets that the target, or a dependency it is executing, waits for.
+	// It is guarded by runsMu, and used to detect dependency cycles across parallel dependencies.
+	waiting map[*GnobMakeTarget]int
 }
 
+type GnobtargetStackKey struct{}
+
+// jobSlotKey is the key of the job slots in the context of a target that holds one of them.
+type GnobjobSlotKey struct{}
+
 // New construct a makefile from the given targets.
 // The name of the program is taken from the first argument of os.Args.
 // The argument list is taken from the second argument of os.Args.
@@ -1112,50 +3311,192 @@ func (Gnob_makefile) NewEx(name string, args []string, targets ...GnobMakeTarget
 	for i := range targets {
 		tgt = append(tgt, &targets[i])
 	}
-	td := GnobMakefile{
-		name:    name,
-		args:    args,
-		targets: tgt,
+	td := &GnobMakefile{
+		GnobmakefileState: &GnobmakefileState{
+			name:    name,
+			args:    args,
+			targets: tgt,
+			jobs:    runtime.NumCPU(),
+		},
 	}
 	td.normalize()
-	return &td
+	td.logHooks()
+	return td
 }
 
 // Depend executes the targets with the given names.
 // Execution is done in the order of the names.
 // If any of the targets is not found, it returns an error.
 // If any of the targets encounters an error, it returns the error immediately.
+// In keep-going mode, the remaining targets are still executed, and the errors are joined together.
 // If all targets are executed successfully, it returns nil.
 func (mf *GnobMakefile) Depend(ctx context.Context, names ...string) error {
-	if len(names) == 0 {
-		return nil
+	targets, err := mf.findAll(names)
+	if err != nil {
+		return err
 	}
-	targets := make([]*GnobMakeTarget, 0, len(names))
-	for _, name := range names {
-		found := mf.Find(name)
-		if found != nil {
-			targets = append(targets, found)
-			continue
+	return mf.execAll(ctx, targets)
+}
+
+// execAll executes the targets in order.
+// It stops at the first error, unless the Makefile is in keep-going mode.
+func (mf *GnobMakefile) execAll(ctx context.Context, targets []*GnobMakeTarget) error {
+	var errs []error
+	for _, tgt := range targets {
+		if err := tgt.exec(ctx, mf); err != nil {
+			if !mf.keepGoing {
+				return err
+			}
+			errs = append(errs, err)
 		}
-		return fmt.Errorf("unknown target: %s", name)
 	}
+	return errors.Join(errs...)
+}
+
+// DependParallel is like Depend, but executes the targets concurrently.
+// At most Jobs targets are executed at the same time, counting the targets of all the calls to DependParallel.
+// In dry-run mode, the targets are executed in order, like Depend.
+// If any of the targets is not found, it returns an error before executing anything.
+// If any of the targets encounters an error, the context of the other targets is cancelled
+// and no more targets are started, unless the Makefile is in keep-going mode.
+// The errors of all the failed targets are joined together.
+func (mf *GnobMakefile) DependParallel(ctx context.Context, names ...string) error {
+	targets, err := mf.findAll(names)
+	if err != nil {
+		return err
+	}
+	if mf.dryRun {
+		return mf.Depend(ctx, names...)
+	}
+	return mf.execParallel(ctx, targets)
+}
+
+// DryRun returns true if the Makefile is running in dry-run mode, with `gnob -n`.
+// In dry-run mode, the targets that would run are printed in order instead of executed.
+func (mf *GnobMakefile) DryRun() bool {
+	return mf.dryRun
+}
+
+// execParallel executes the targets concurrently, at most Jobs at the same time.
+// The job slot of the calling target, if any, is released while it waits for the targets.
+func (mf *GnobMakefile) execParallel(ctx context.Context, targets []*GnobMakeTarget) error {
+	defer mf.releaseJob(ctx)()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := mf.jobSemaphore()
+	jobCtx := context.WithValue(ctx, GnobjobSlotKey{}, sem)
 	for _, tgt := range targets {
-		if err := tgt.exec(ctx, mf); err != nil {
-			return err
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := tgt.exec(jobCtx, mf); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				if !mf.keepGoing {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return ctx.Err()
+}
+
+// jobSemaphore returns the job slots of the Makefile, creating them on first use.
+func (mf *GnobMakefile) jobSemaphore() chan struct{} {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	if mf.jobSlots == nil {
+		mf.jobSlots = make(chan struct{}, mf.jobs)
+	}
+	return mf.jobSlots
+}
+
+// releaseJob releases the job slot held by the target of the context while it waits for other targets,
+// so that they can use it. The returned function takes a job slot again once the wait is over.
+// The slot is returned to the job slots it was taken from, even if SetJobs replaced them since.
+func (mf *GnobMakefile) releaseJob(ctx context.Context) func() {
+	sem, _ := ctx.Value(GnobjobSlotKey{}).(chan struct{})
+	if sem == nil {
+		return func() {}
+	}
+	<-sem
+	return func() { sem <- struct{}{} }
+}
+
+// State returns the state recorded in the state database for the target with the given name.
+// It returns false if the target has never been executed with a StateUpToDate function.
+func (mf *GnobMakefile) State(name string) (GnobTargetState, bool) {
+	if tgt := mf.Find(name); tgt != nil {
+		name = tgt.Name
+	}
+	var states map[string]GnobTargetState
+	if err := GnobloadState(GnobtargetStateFile, &states); err != nil {
+		GnobLogger.Warn("[gnob:makefile] unable to load target state", "error", err)
+		return GnobTargetState{}, false
+	}
+	state, ok := states[name]
+	return state, ok
+}
+
+// Jobs returns the maximum number of targets DependParallel executes at the same time, across all its calls.
+func (mf *GnobMakefile) Jobs() int {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	return mf.jobs
+}
+
+// SetJobs sets the maximum number of targets DependParallel executes at the same time.
+// If n is less than 1, the number of CPUs is used.
+// This can also be set on the command line with `gnob -j N <target>`.
+// When called while targets are executing, the new limit applies to the targets started afterwards.
+func (mf *GnobMakefile) SetJobs(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	mf.runsMu.Lock()
+	mf.jobs = n
+	mf.jobSlots = nil
+	mf.runsMu.Unlock()
+}
+
+func (mf *GnobMakefile) findAll(names []string) ([]*GnobMakeTarget, error) {
+	targets := make([]*GnobMakeTarget, 0, len(names))
+	for _, name := range names {
+		found, err := mf.lookup(name)
+		if err != nil {
+			return nil, err
 		}
+		targets = append(targets, found)
 	}
-	return nil
+	return targets, nil
 }
 
 // Find returns the target with the given name.
-// If the target is not found, it returns nil.
+// If no target has the name, the target is created from the pattern target that matches it.
+// Otherwise, the name may be a short name, like `build` for `docker:build`, if only one target has it.
+// When called from the Body of a mounted target, the name is first resolved in the namespace of the target.
+// If the target is not found, or the short name is ambiguous, it returns nil.
 func (mf *GnobMakefile) Find(name string) *GnobMakeTarget {
-	for _, tgt := range mf.targets {
-		if strings.EqualFold(tgt.Name, name) {
-			return tgt
-		}
-	}
-	return nil
+	tgt, _ := mf.lookup(name)
+	return tgt
 }
 
 // Add adds more targets to the Makefile.
@@ -1167,46 +3508,134 @@ func (mf *GnobMakefile) Add(tgts ...GnobMakeTarget) {
 }
 
 // TargetArgs returns the argument list for the target.
+// The flags declared by the target are parsed from the argument list, and only the remaining arguments are returned.
+// Targets that were not given on the command line, like dependencies, have no arguments.
 func (mf *GnobMakefile) TargetArgs() []string {
+	if mf.run != nil {
+		return mf.run.cmd.args
+	}
 	return mf.commandArgs
 }
 
 // Run runs the target.
+// When it finishes, it prints the summary of the executed targets.
 // If it encounters an error, it logs the error and exits with status code 1.
 func (mf *GnobMakefile) Run(ctx context.Context) {
 	mf.ctx = ctx
-	if err := mf.RunE(ctx); err != nil {
+	err := mf.RunE(ctx)
+	mf.showSummary()
+	if err != nil {
 		GnobLogger.Error("[gnob:makefile] error running build target", "error", err)
 		os.Exit(1)
 	}
 }
 
-// RunE runs the target and returns an error if any.
+// RunE runs the targets given on the command line and returns an error if any.
+// Several targets can be given, each followed by its own flags and arguments, like `gnob build -race test example`.
+// They are executed in order, or concurrently when the -parallel flag is given.
+// Each target is executed at most once per call to RunE,
+// no matter how many other targets depend on it.
 func (mf *GnobMakefile) RunE(ctx context.Context) error {
-	if len(mf.args) == 0 {
-		return mf.runDefault(ctx)
+	if err := mf.Validate(); err != nil {
+		return err
+	}
+	mf.resetRuns()
+	mf.runsMu.Lock()
+	mf.commandLines = nil
+	mf.runsMu.Unlock()
+	mf.parallel = false
+	mf.dryRun = false
+	mf.keepGoing = false
+	mf.summaryFile = ""
+	mf.cmdVars = nil
+	if len(mf.args) > 0 && mf.args[0] == GnobcompleteCommand {
+		return mf.showCompletions(mf.args[1:])
+	}
+	opts, args, err := mf.parseOptions(mf.args)
+	if err != nil {
+		return err
+	}
+	mf.commandArgs = args
+	if opts.trace != "" {
+		defer func() {
+			if err := Gnobtraces.write(false); err != nil {
+				GnobLogger.Warn("[gnob:makefile] unable to write trace", "error", err)
+			}
+			Gnobtraces.reset()
+		}()
 	}
-	cmd := mf.args[0]
-	mf.commandArgs = mf.args[1:]
-	if cmd == "-help" {
+	if opts.dir != "" {
+		if err = os.Chdir(opts.dir); err != nil {
+			return fmt.Errorf("unable to change directory: %w", err)
+		}
+	}
+	if err = mf.loadVars(opts.vars); err != nil {
+		return err
+	}
+	switch {
+	case opts.help:
 		return mf.showHelp()
+	case opts.list != "":
+		return mf.showList(opts.list)
+	case opts.graph != "":
+		return mf.showGraph(ctx, string(opts.graph))
+	case opts.completion != "":
+		return mf.showCompletion(opts.completion)
+	case opts.genDocs != "":
+		return mf.WriteDocs(os.Stdout, opts.genDocs)
+	}
+	targets, err := mf.parseCommandLine(args)
+	if err == nil && len(targets) == 0 {
+		if mf.defaultTarget >= 0 {
+			targets = []*GnobMakeTarget{mf.targets[mf.defaultTarget]}
+		} else {
+			var tgt *GnobMakeTarget
+			if tgt, err = mf.pickTarget(); err == nil {
+				targets = []*GnobMakeTarget{tgt}
+			}
+		}
+	}
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return targets[0].showHelp(mf)
+		}
+		return err
 	}
-	tgt := mf.Find(cmd)
-	if tgt == nil {
-		return fmt.Errorf("unknown target: %s", cmd)
+	if opts.watch {
+		return mf.watch(ctx, targets)
 	}
-	return tgt.exec(ctx, mf)
+	return mf.runTargets(ctx, targets)
 }
 
-func (mf *GnobMakefile) runDefault(ctx context.Context) error {
-	return mf.targets[mf.defaultTarget].exec(ctx, mf)
+// runTargets executes the targets given on the command line, calling the BeforeRun and AfterRun hooks.
+func (mf *GnobMakefile) runTargets(ctx context.Context, targets []*GnobMakeTarget) error {
+	for _, fn := range mf.hooks.beforeRun {
+		fn(ctx, targets)
+	}
+	var err error
+	if mf.parallel && !mf.dryRun {
+		err = mf.execParallel(ctx, targets)
+	} else {
+		err = mf.execAll(ctx, targets)
+	}
+	if mf.keepGoing {
+		err = mf.keepGoingResult(err)
+	}
+	for _, fn := range mf.hooks.afterRun {
+		fn(ctx, err)
+	}
+	return err
 }
 
 func (mf *GnobMakefile) showHelp() error {
-	if len(mf.commandArgs) > 0 {
-		tgt := mf.Find(mf.commandArgs[0])
-		if tgt == nil {
-			return fmt.Errorf("unknown target: %s", mf.commandArgs[0])
+	args := mf.commandArgs
+	for len(args) > 0 && mf.parseVar(args[0]) {
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		tgt, err := mf.lookup(args[0])
+		if err != nil {
+			return err
 		}
 		return tgt.showHelp(mf)
 	}
@@ -1217,18 +3646,41 @@ func (mf *GnobMakefile) showHelp() error {
 		}
 		maxLen = max(maxLen, len(tgt.Name))
 	}
-	fmt.Println("Usage: " + filepath.Base(mf.name) + " [-help] [target]")
-	fmt.Println("Targets:")
-	fmtStr := fmt.Sprintf("%%-%ds   %%s\n", maxLen)
-	for i, tgt := range mf.targets {
-		if tgt.Hidden {
+	for _, pt := range mf.patterns {
+		if pt.Hidden {
 			continue
 		}
-		if mf.defaultTarget == i {
-			fmt.Printf("* "+fmtStr, tgt.Name, tgt.Desc)
-			continue
+		maxLen = max(maxLen, len(pt.Name))
+	}
+	fmt.Println("Usage: " + filepath.Base(mf.name) + " [flags] [target [flags] [args]...]")
+	fmt.Println("Flags:")
+	fs, _ := mf.optionSet()
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+	mf.showVars()
+	fmtStr := fmt.Sprintf("%%-%ds   %%s\n", maxLen)
+	for _, ns := range mf.namespaces() {
+		if ns == "" {
+			fmt.Println("Targets:")
+		} else {
+			fmt.Printf("\n%s:\n", ns)
+		}
+		for i, tgt := range mf.targets {
+			if tgt.Hidden || GnobnamespaceOf(tgt.Name) != ns {
+				continue
+			}
+			if mf.defaultTarget == i {
+				fmt.Printf("* "+fmtStr, tgt.Name, tgt.Desc)
+				continue
+			}
+			fmt.Printf("  "+fmtStr, tgt.Name, tgt.Desc)
+		}
+		for _, pt := range mf.patterns {
+			if pt.Hidden || GnobnamespaceOf(pt.Name) != ns {
+				continue
+			}
+			fmt.Printf("  "+fmtStr, pt.Name, pt.Desc)
 		}
-		fmt.Printf("  "+fmtStr, tgt.Name, tgt.Desc)
 	}
 	fmt.Println("\n* (default target)")
 	return nil
@@ -1240,67 +3692,439 @@ func (mf *GnobMakefile) normalize() {
 	for i := range mf.targets {
 		name := strings.ToLower(mf.targets[i].Name)
 		if _, ok := names[name]; ok {
-			GnobLogger.Warn("[gnob] duplicate target", "name", name, "index", i)
-			// skip duplicate targets
+			GnobLogger.Debug("[gnob] duplicate target", "name", name, "index", i)
+			// skip duplicate targets, which are reported by Validate
+			mf.duplicates = append(mf.duplicates, mf.targets[i].Name)
 			continue
 		}
 		normalTargets = append(normalTargets, mf.targets[i])
 		names[name] = struct{}{}
 	}
 	slices.SortFunc(normalTargets, func(a, b *GnobMakeTarget) int {
-		if a.Default {
-			return -1
-		}
-		if b.Default {
+		if a.Default != b.Default {
+			if a.Default {
+				return -1
+			}
 			return 1
 		}
 		return strings.Compare(a.Name, b.Name)
 	})
 	mf.targets = normalTargets
-	mf.defaultTarget = 0
+	mf.defaultTarget = -1
 	for i := range mf.targets {
 		if mf.targets[i].Default {
 			mf.defaultTarget = i
 			break
 		}
 	}
+	mf.depsErr = mf.resolveDeps()
+}
+
+// resolveDeps validates the Deps of all targets.
+// It returns an error if a dependency refers to an unknown target,
+// or if the dependencies do not form a directed acyclic graph.
+func (mf *GnobMakefile) resolveDeps() error {
+	var errs []error
+	for _, tgt := range mf.targets {
+		for _, dep := range tgt.Deps {
+			if mf.Find(dep) == nil {
+				errs = append(errs, fmt.Errorf("target %s: unknown dependency: %s%s", tgt.Name, dep, mf.suggest(dep)))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*GnobMakeTarget]int, len(mf.targets))
+	var path []*GnobMakeTarget
+	var visit func(tgt *GnobMakeTarget) error
+	visit = func(tgt *GnobMakeTarget) error {
+		switch state[tgt] {
+		case visited:
+			return nil
+		case visiting:
+			return GnobcycleError(append(path[slices.Index(path, tgt):], tgt))
+		}
+		state[tgt] = visiting
+		path = append(path, tgt)
+		for _, dep := range tgt.Deps {
+			if err := visit(mf.Find(dep)); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[tgt] = visited
+		return nil
+	}
+	for _, tgt := range mf.targets {
+		if err := visit(tgt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cycleError returns an error describing the dependency cycle.
+// The path must start and end with the same target.
+func GnobcycleError(path []*GnobMakeTarget) error {
+	names := make([]string, 0, len(path))
+	for _, tgt := range path {
+		names = append(names, tgt.Name)
+	}
+	return fmt.Errorf("dependency cycle: %s", strings.Join(names, " -> "))
+}
+
+// MakeTarget is a target that can be executed by a Makefile.
+type GnobMakeTarget struct {
+	// Name is the name of the target.
+	Name string
+	// Desc is a short description of the target.
+	// It is shown when listing all targets with `gnob -help`.
+	Desc string
+	// LongDesc is a long description of the target.
+	// It is shown when running `gnob -help <target>`.
+	LongDesc string
+	// Hidden is true if the target should be hidden from listing.
+	Hidden bool
+	// Default is true if the target is the default target.
+	// Only one target can be the default target.
+	Default bool
+	// Flags are the flags the target accepts on the command line, like `gnob build -race`.
+	// Their values can be read from the Body with Makefile.Bool, Makefile.String, Makefile.Int, and Makefile.Duration.
+	// When the target is executed as a dependency, the flags have their default values.
+	Flags []GnobTargetFlag
+	// Deps are the names of the targets this target depends on.
+	// They are executed in order, before checking UpToDate and executing the Body.
+	// Every dependency must name a known target, and the dependencies must not form a cycle.
+	Deps []string
+	// Inputs are the files the target is built from. They may be glob patterns.
+	Inputs []string
+	// Outputs are the files the target produces. They may be glob patterns.
+	// If the target has Outputs and no UpToDate function, it is up-to-date when every output exists
+	// and is newer than all the Inputs.
+	// After the Body succeeds, every output must exist, otherwise the target fails.
+	Outputs []string
+	// UpToDate is a function that returns true if the target is up-to-date.
+	// If the target is up-to-date, the target will not be executed.
+	// It takes precedence over the up-to-date check derived from Inputs and Outputs.
+	UpToDate func(mf *GnobMakefile) bool
+	// Body is the function that executes the target.
+	// When the target is not up-to-date, this body will be executed.
+	Body func(ctx context.Context, mf *GnobMakefile) error
+	// Timeout is the maximum time each execution of the Body may take.
+	// The context passed to the Body is cancelled when it expires, and the target fails with ErrTargetTimeout.
+	// If it is zero, there is no timeout.
+	Timeout time.Duration
+	// Retries is the number of times the Body is executed again after it fails or times out.
+	Retries int
+	// RetryBackoff is the delay before the first retry, which doubles after each retry.
+	// If it is zero, the delay starts at one second.
+	RetryBackoff time.Duration
+	// EchoCommands is true if the Body only has side effects through the commands it executes with Cmd.Exec.
+	// In dry-run mode, the Body of such a target is called, and its commands are printed instead of executed.
+	// The Body of other targets is never called in dry-run mode.
+	EchoCommands bool
+	// namespace is the prefix under which the target was mounted with Makefile.Mount, if any.
+	// Names given to Find by the Body of the target are resolved in this namespace first.
+	namespace string
+}
+
+// exec executes the target at most once per run of the Makefile.
+// If the target is already executing, it waits for it to finish and returns the same result.
+func (mt *GnobMakeTarget) exec(ctx context.Context, mf *GnobMakefile) error {
+	stack, _ := ctx.Value(GnobtargetStackKey{}).([]*GnobMakeTarget)
+	if i := slices.Index(stack, mt); i >= 0 {
+		return GnobcycleError(append(slices.Clip(stack[i:]), mt))
+	}
+	mf.runsMu.Lock()
+	if len(stack) > 0 {
+		if mf.edges == nil {
+			mf.edges = make(map[GnobmakeEdge]struct{})
+		}
+		mf.edges[GnobmakeEdge{from: stack[len(stack)-1], to: mt}] = struct{}{}
+	}
+	if mf.runs == nil {
+		mf.runs = make(map[*GnobMakeTarget]*GnobtargetRun)
+	}
+	run, ok := mf.runs[mt]
+	if !ok {
+		run = &GnobtargetRun{done: make(chan struct{}), cmd: mf.commandLines[mt]}
+		if run.cmd.flags == nil {
+			run.cmd.flags = mt.flagSet()
+		}
+		mf.runs[mt] = run
+	}
+	if ok {
+		select {
+		case <-run.done:
+		default:
+			if path := mf.waitCycle(stack, mt); path != nil {
+				mf.runsMu.Unlock()
+				return GnobcycleError(path)
+			}
+			mf.addWait(stack, mt, 1)
+			defer func() {
+				mf.runsMu.Lock()
+				mf.addWait(stack, mt, -1)
+				mf.runsMu.Unlock()
+			}()
+		}
+	}
+	mf.runsMu.Unlock()
+	if ok {
+		GnobLogger.Debug("[gnob:makefile] target already executed", "target", mt.Name)
+		defer mf.releaseJob(ctx)()
+		select {
+		case <-run.done:
+			return run.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ctx = context.WithValue(ctx, GnobtargetStackKey{}, append(slices.Clip(stack), mt))
+	span := Gnobtraces.start("target", mt.Name, nil)
+	run.err = mt.run(ctx, &GnobMakefile{GnobmakefileState: mf.GnobmakefileState, target: mt, run: run})
+	if span != nil {
+		span.args = map[string]any{"result": run.result}
+	}
+	span.end(run.err)
+	mf.runsMu.Lock()
+	run.end = time.Now()
+	mf.finished = append(mf.finished, mt)
+	mf.runsMu.Unlock()
+	close(run.done)
+	return run.err
+}
+
+// waitCycle returns the dependency cycle that waiting for the running target mt would create, or nil if there is none.
+// Waiting for mt creates a cycle if mt, or a target it waits for, waits for one of the targets on the stack.
+// It must be called with runsMu held.
+func (mf *GnobMakefile) waitCycle(stack []*GnobMakeTarget, mt *GnobMakeTarget) []*GnobMakeTarget {
+	visited := make(map[*GnobMakeTarget]bool)
+	// walk returns the targets from tgt to the target on the stack it waits for
+	var walk func(tgt *GnobMakeTarget) []*GnobMakeTarget
+	walk = func(tgt *GnobMakeTarget) []*GnobMakeTarget {
+		if slices.Contains(stack, tgt) {
+			return []*GnobMakeTarget{tgt}
+		}
+		if visited[tgt] || mf.runs[tgt] == nil {
+			return nil
+		}
+		visited[tgt] = true
+		for next := range mf.runs[tgt].waiting {
+			if path := walk(next); path != nil {
+				return append([]*GnobMakeTarget{tgt}, path...)
+			}
+		}
+		return nil
+	}
+	path := walk(mt)
+	if path == nil {
+		return nil
+	}
+	i := slices.Index(stack, path[len(path)-1])
+	return append(slices.Clone(stack[i:]), path...)
+}
+
+// addWait adds delta to the number of times the targets on the stack wait for the running target mt.
+// It must be called with runsMu held.
+func (mf *GnobMakefile) addWait(stack []*GnobMakeTarget, mt *GnobMakeTarget, delta int) {
+	for _, tgt := range stack {
+		run := mf.runs[tgt]
+		if run == nil {
+			continue
+		}
+		if run.waiting == nil {
+			run.waiting = make(map[*GnobMakeTarget]int)
+		}
+		if run.waiting[mt] += delta; run.waiting[mt] <= 0 {
+			delete(run.waiting, mt)
+		}
+	}
+}
+
+// afterSuccess registers a function that is called after the body of the executing target succeeds.
+// It is used by UpToDate functions to record the state of a target once it has been built.
+// It returns false if the Makefile is not executing a target.
+func (mf *GnobMakefile) afterSuccess(fn func() error) bool {
+	if mf.run == nil {
+		return false
+	}
+	mf.run.onSuccess = append(mf.run.onSuccess, fn)
+	return true
+}
+
+// run executes the dependencies of the target, and then builds it.
+// The lifecycle hooks of the Makefile are called once the dependencies of the target finished.
+func (mt *GnobMakeTarget) run(ctx context.Context, mf *GnobMakefile) error {
+	if err := mf.Depend(ctx, mt.Deps...); err != nil {
+		mf.run.result = GnobTargetSkipped
+		return err
+	}
+	mf.run.start = time.Now()
+	Gnobemit(ctx, mf.hooks.targetStart, GnobTargetEvent{Target: mt})
+	err := mt.build(ctx, mf)
+	ev := GnobTargetEvent{Target: mt, Result: mf.run.result, Duration: time.Since(mf.run.start), Err: err}
+	switch {
+	case mf.run.result == GnobTargetUpToDate:
+		Gnobemit(ctx, mf.hooks.upToDate, ev)
+	case err != nil:
+		Gnobemit(ctx, mf.hooks.onError, ev)
+	}
+	Gnobemit(ctx, mf.hooks.targetFinish, ev)
+	return err
+}
+
+// build executes the body of the target unless it is up-to-date, and records its result.
+// In dry-run mode, a target is not up-to-date if any of its dependencies would run, like with `make -n`,
+// since they would change the files it is built from.
+func (mt *GnobMakeTarget) build(ctx context.Context, mf *GnobMakefile) error {
+	if !(mf.dryRun && mf.depsWouldRun(mt)) && mt.upToDate(mf) {
+		mf.run.result = GnobTargetUpToDate
+		if mf.dryRun {
+			fmt.Printf("%s (up-to-date)\n", mt.Name)
+		}
+		return nil
+	}
+	if mf.dryRun {
+		return mt.dryRun(ctx, mf)
+	}
+	start := time.Now()
+	err := mt.attempt(ctx, mf)
+	backoff := cmp.Or(mt.RetryBackoff, time.Second)
+retries:
+	for retry := 0; err != nil && retry < mt.Retries && ctx.Err() == nil; retry++ {
+		GnobLogger.Warn("[gnob:makefile] retrying target", "target", mt.Name, "attempt", retry+2, "delay", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			err = errors.Join(err, ctx.Err())
+			break retries
+		}
+		backoff *= 2
+		err = mt.attempt(ctx, mf)
+	}
+	if err == nil {
+		var errs []error
+		for _, fn := range mf.run.onSuccess {
+			errs = append(errs, fn())
+		}
+		err = errors.Join(errs...)
+	}
+	switch {
+	case err == nil:
+		mf.run.result = GnobTargetSucceeded
+	case errors.Is(err, GnobErrTargetTimeout):
+		mf.run.result = GnobTargetTimedOut
+	default:
+		mf.run.result = GnobTargetFailed
+	}
+	if state := mf.run.state; state != nil {
+		state.LastRun = start
+		state.Duration = time.Since(start)
+		state.Result = mf.run.result
+		if err != nil {
+			state.Error = err.Error()
+		}
+		if serr := GnobupdateState(GnobtargetStateFile, func(states map[string]GnobTargetState) {
+			states[mt.Name] = *state
+		}); serr != nil {
+			GnobLogger.Warn("[gnob:makefile] unable to record target state", "target", mt.Name, "error", serr)
+		}
+	}
+	return err
+}
+
+// attempt executes the Body of the target once, within its Timeout, and checks its Outputs.
+func (mt *GnobMakeTarget) attempt(ctx context.Context, mf *GnobMakefile) error {
+	mf.run.attempts++
+	if mt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, mt.Timeout, GnobErrTargetTimeout)
+		defer cancel()
+	}
+	err := mt.Body(ctx, mf)
+	if mt.Timeout > 0 && errors.Is(context.Cause(ctx), GnobErrTargetTimeout) {
+		if err == nil {
+			return fmt.Errorf("%w after %s", GnobErrTargetTimeout, mt.Timeout)
+		}
+		return fmt.Errorf("%w after %s: %w", GnobErrTargetTimeout, mt.Timeout, err)
+	}
+	if err != nil {
+		return err
+	}
+	return mt.checkOutputs()
+}
+
+// depsWouldRun returns true if any of the targets that mt depended on so far would run in dry-run mode,
+// because they are not up-to-date.
+func (mf *GnobMakefile) depsWouldRun(mt *GnobMakeTarget) bool {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	for e := range mf.edges {
+		if e.from != mt {
+			continue
+		}
+		if run := mf.runs[e.to]; run != nil && run.result != GnobTargetUpToDate {
+			return true
+		}
+	}
+	return false
 }
 
-// MakeTarget is a target that can be executed by a Makefile.
-type GnobMakeTarget struct {
-	// Name is the name of the target.
-	Name string
-	// Desc is a short description of the target.
-	// It is shown when listing all targets with `gnob -help`.
-	Desc string
-	// LongDesc is a long description of the target.
-	// It is shown when running `gnob -help <target>`.
-	LongDesc string
-	// Hidden is true if the target should be hidden from listing.
-	Hidden bool
-	// Default is true if the target is the default target.
-	// Only one target can be the default target.
-	Default bool
-	// UpToDate is a function that returns true if the target is up-to-date.
-	// If the target is up-to-date, the target will not be executed.
-	UpToDate func(mf *GnobMakefile) bool
-	// Body is the function that executes the target.
-	// When the target is not up-to-date, this body will be executed.
-	Body func(ctx context.Context, mf *GnobMakefile) error
+// dryRun prints that the target would run, and the commands it would execute if it has EchoCommands.
+func (mt *GnobMakeTarget) dryRun(ctx context.Context, mf *GnobMakefile) error {
+	fmt.Println(mt.Name)
+	if !mt.EchoCommands {
+		return nil
+	}
+	var cmd Gnob_cmd
+	return mt.Body(cmd.DryRun(ctx, os.Stdout), mf)
 }
 
-// Exec executes the target.
-func (mt *GnobMakeTarget) exec(ctx context.Context, mf *GnobMakefile) error {
-	GnobLogger.Debug("[gnob:makefile] execute target", "target", mt.Name)
-	if mt.UpToDate == nil || !mt.UpToDate(mf) {
-		if err := mt.Body(ctx, mf); err != nil {
-			GnobLogger.Error("[gnob:makefile] error executing target", "target", mt.Name, "error", err)
-			return err
+// upToDate returns true if the target is up-to-date.
+// It uses the UpToDate function if there is one, and otherwise compares the modification times
+// of the Inputs and Outputs.
+func (mt *GnobMakeTarget) upToDate(mf *GnobMakefile) bool {
+	if mt.UpToDate != nil {
+		return mt.UpToDate(mf)
+	}
+	if len(mt.Outputs) == 0 {
+		return false
+	}
+	var f Gnob_files
+	var oldest time.Time
+	for _, output := range mt.Outputs {
+		matches, _ := filepath.Glob(output)
+		if len(matches) == 0 {
+			return false
+		}
+		for _, p := range matches {
+			ts := f.modTime(p)
+			if ts.IsZero() {
+				return false
+			}
+			if oldest.IsZero() || ts.Before(oldest) {
+				oldest = ts
+			}
 		}
-		return nil
 	}
-	GnobLogger.Info("[gnob:makefile] target is up-to-date", "target", mt.Name)
-	return nil
+	return !f.LatestTimestamp(mt.Inputs...).After(oldest)
+}
+
+// checkOutputs returns an error if any of the Outputs of the target does not exist.
+func (mt *GnobMakeTarget) checkOutputs() error {
+	var errs []error
+	for _, output := range mt.Outputs {
+		if matches, _ := filepath.Glob(output); len(matches) == 0 {
+			errs = append(errs, fmt.Errorf("target %s: missing output: %s", mt.Name, output))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (mt *GnobMakeTarget) showHelp(mf *GnobMakefile) error {
@@ -1311,6 +4135,19 @@ func (mt *GnobMakeTarget) showHelp(mf *GnobMakefile) error {
 	if mt.LongDesc != "" {
 		fmt.Println(mt.LongDesc)
 	}
+	if len(mt.Deps) > 0 {
+		fmt.Println()
+		fmt.Println("Dependencies: " + strings.Join(mt.Deps, ", "))
+	}
+	mt.showFlags()
+	if len(mt.Inputs) > 0 {
+		fmt.Println()
+		fmt.Println("Inputs: " + strings.Join(mt.Inputs, ", "))
+	}
+	if len(mt.Outputs) > 0 {
+		fmt.Println()
+		fmt.Println("Outputs: " + strings.Join(mt.Outputs, ", "))
+	}
 	return nil
 }
 
@@ -1482,3 +4319,675 @@ func (t Gnob_template) funcMap() template.FuncMap {
 	}
 	return funcMap
 }
+
+// traceEvent is an event in the Chrome trace-event format, which can be loaded in Perfetto or chrome://tracing.
+// Timestamps and durations are in microseconds.
+type GnobtraceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   int64          `json:"ts"`
+	Dur  int64          `json:"dur,omitempty"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// traceFile is the JSON object format of a Chrome trace file.
+type GnobtraceFile struct {
+	TraceEvents []GnobtraceEvent `json:"traceEvents"`
+}
+
+// tracer records the spans of targets and commands while tracing is enabled with `gnob -trace <file>`.
+// Spans that overlap in time, like targets running concurrently or the commands of a pipeline,
+// are placed on different lanes, so that they are shown correctly.
+type Gnobtracer struct {
+	mu     sync.Mutex
+	file   string
+	events []GnobtraceEvent
+	lanes  []bool
+}
+
+var Gnobtraces Gnobtracer
+
+// traceSpan is a span started by the tracer.
+// A nil traceSpan is valid, and is returned when tracing is disabled.
+
+// traceSpan is a span started by the tracer.
+// A nil traceSpan is valid, and is returned when tracing is disabled.
+type GnobtraceSpan struct {
+	t     *Gnobtracer
+	name  string
+	cat   string
+	lane  int
+	start time.Time
+	args  map[string]any
+}
+
+// enable starts recording spans, to be written to the given file.
+// A relative file is resolved against the current working directory.
+func (t *Gnobtracer) enable(file string) {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file = file
+}
+
+// reset stops recording spans, and discards the recorded spans.
+func (t *Gnobtracer) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file = ""
+	t.events = nil
+}
+
+// start starts a span with the given category and name on the first free lane.
+// It returns nil if tracing is disabled.
+func (t *Gnobtracer) start(cat string, name string, args map[string]any) *GnobtraceSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == "" {
+		return nil
+	}
+	lane := slices.Index(t.lanes, false)
+	if lane < 0 {
+		lane = len(t.lanes)
+		t.lanes = append(t.lanes, true)
+	}
+	t.lanes[lane] = true
+	return &GnobtraceSpan{t: t, name: name, cat: cat, lane: lane, start: time.Now(), args: args}
+}
+
+// end ends the span, recording the error if any, and frees its lane.
+func (s *GnobtraceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	if err != nil {
+		if s.args == nil {
+			s.args = make(map[string]any)
+		}
+		s.args["error"] = err.Error()
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.lanes[s.lane] = false
+	s.t.events = append(s.t.events, GnobtraceEvent{
+		Name: s.name,
+		Cat:  s.cat,
+		Ph:   "X",
+		Ts:   s.start.UnixMicro(),
+		Dur:  max(end.Sub(s.start).Microseconds(), 1),
+		Pid:  os.Getpid(),
+		Tid:  s.lane + 1,
+		Args: s.args,
+	})
+}
+
+// write writes the recorded spans to the trace file.
+// If merge is true, the spans are added to the events already in the file,
+// which is used to add the spans of a parent process to the trace of the gnob binary it executed.
+func (t *Gnobtracer) write(merge bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == "" {
+		return nil
+	}
+	var tf GnobtraceFile
+	if merge {
+		data, err := os.ReadFile(t.file)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to read trace file %q: %w", t.file, err)
+		}
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &tf); err != nil {
+				return fmt.Errorf("unable to decode trace file %q: %w", t.file, err)
+			}
+		}
+	}
+	tf.TraceEvents = append(tf.TraceEvents, GnobtraceEvent{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  os.Getpid(),
+		Args: map[string]any{"name": filepath.Base(os.Args[0])},
+	})
+	tf.TraceEvents = append(tf.TraceEvents, t.events...)
+	data, err := json.Marshal(tf)
+	if err != nil {
+		return fmt.Errorf("unable to encode trace file %q: %w", t.file, err)
+	}
+	if err = os.WriteFile(t.file, data, 0o644); err != nil {
+		return fmt.Errorf("unable to write trace file %q: %w", t.file, err)
+	}
+	return nil
+}
+
+// traceFlag returns the value of the -trace flag in the argument list, if any.
+// It is used before the Makefile parses its flags, so that rebuilding gnob can be traced too.
+func GnobtraceFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "trace" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// Validate checks the targets of the Makefile, and returns the problems it finds joined together:
+// several targets with the same name, several targets with Default set, targets without a Body,
+// and dependencies on unknown targets or that form a cycle.
+// It is called by RunE before executing any target.
+func (mf *GnobMakefile) Validate() error {
+	var errs []error
+	for _, name := range mf.duplicates {
+		errs = append(errs, fmt.Errorf("duplicate target: %s", name))
+	}
+	var defaults []string
+	for _, tgt := range mf.targets {
+		if tgt.Default {
+			defaults = append(defaults, tgt.Name)
+		}
+		if tgt.Body == nil {
+			errs = append(errs, fmt.Errorf("target %s: no Body", tgt.Name))
+		}
+	}
+	if len(defaults) > 1 {
+		errs = append(errs, fmt.Errorf("multiple default targets: %s", strings.Join(defaults, ", ")))
+	}
+	for _, pt := range mf.patterns {
+		if pt.Body == nil {
+			errs = append(errs, fmt.Errorf("pattern target %s: no Body", pt.Name))
+		}
+	}
+	if mf.depsErr != nil {
+		errs = append(errs, mf.depsErr)
+	}
+	return errors.Join(errs...)
+}
+
+// suggest returns a suggestion of the names of the targets that are not hidden
+// and are closest to the given unknown name, like ` (did you mean test?)`.
+// It returns an empty string if no name is close enough.
+func (mf *GnobMakefile) suggest(name string) string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	name = strings.ToLower(name)
+	limit := max(1, (len(name)+2)/3)
+	var candidates []candidate
+	for _, tgt := range mf.targets {
+		if tgt.Hidden {
+			continue
+		}
+		d := GnobeditDistance(name, strings.ToLower(tgt.Name))
+		if short := tgt.Name[strings.LastIndexByte(tgt.Name, ':')+1:]; short != tgt.Name {
+			d = min(d, GnobeditDistance(name, strings.ToLower(short)))
+		}
+		if d <= limit {
+			candidates = append(candidates, candidate{name: tgt.Name, distance: d})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.distance, b.distance), strings.Compare(a.name, b.name))
+	})
+	names := make([]string, 0, 3)
+	for _, c := range candidates[:min(len(candidates), 3)] {
+		names = append(names, c.name)
+	}
+	return " (did you mean " + strings.Join(names, " or ") + "?)"
+}
+
+// editDistance returns the number of insertions, deletions, substitutions,
+// and transpositions of adjacent characters needed to turn a into b.
+func GnobeditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// rows i-2, i-1, and i of the distance matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// VarsFiles are the files the variables of a Makefile are read from when no file is given with `gnob -vars <file>`.
+// The first file that exists is read. Files ending in .json contain a JSON object, the others have KEY=value lines.
+var GnobVarsFiles = []string{"gnob.env", "gnob.json"}
+
+// MakeVar is a variable of a Makefile, like the variables of Make.
+// Its value is taken from the first of these that sets it:
+//
+//  1. a `KEY=value` argument on the command line, like `gnob build GOOS=linux`
+//  2. the environment variable with the same name
+//  3. the variables file, like gnob.env
+//  4. the Default of the variable
+//
+// Variables are read from the Body of a target with Makefile.Var, VarBool, VarInt, and VarDuration.
+type GnobMakeVar struct {
+	// Name is the name of the variable, like GOOS.
+	Name string
+	// Default is the value of the variable when it is not set.
+	Default string
+	// Usage is a short description of the variable.
+	// It is shown when running `gnob -help`.
+	Usage string
+}
+
+// varName matches the names of the variables that can be set on the command line.
+var GnobvarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// AddVars declares variables of the Makefile, so that they are listed by `gnob -help` with their default value.
+// Variables that are not declared can be set and read too, but have no default value.
+func (mf *GnobMakefile) AddVars(vars ...GnobMakeVar) {
+	for _, v := range vars {
+		mf.vars = slices.DeleteFunc(mf.vars, func(old GnobMakeVar) bool {
+			return old.Name == v.Name
+		})
+		mf.vars = append(mf.vars, v)
+	}
+	slices.SortFunc(mf.vars, func(a, b GnobMakeVar) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// parseVar records a `KEY=value` argument as the value of a variable given on the command line.
+// It returns false if the argument does not set a variable.
+func (mf *GnobMakefile) parseVar(arg string) bool {
+	name, value, ok := strings.Cut(arg, "=")
+	if !ok || !GnobvarName.MatchString(name) {
+		return false
+	}
+	if mf.cmdVars == nil {
+		mf.cmdVars = make(map[string]string)
+	}
+	mf.cmdVars[name] = value
+	return true
+}
+
+// loadVars reads the variables file. If file is empty, the first of VarsFiles that exists is read, if any.
+func (mf *GnobMakefile) loadVars(file string) error {
+	mf.fileVars = nil
+	if file == "" {
+		for _, f := range GnobVarsFiles {
+			if _, err := os.Stat(f); err == nil {
+				file = f
+				break
+			}
+		}
+		if file == "" {
+			return nil
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("unable to read variables file %q: %w", file, err)
+	}
+	vars := make(map[string]string)
+	if filepath.Ext(file) == ".json" {
+		var values map[string]json.RawMessage
+		if err = json.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("unable to decode variables file %q: %w", file, err)
+		}
+		for k, v := range values {
+			// strings are decoded, while numbers and booleans are kept as written, so 1000000 is not 1e+06
+			switch v[0] {
+			case '"':
+				var s string
+				if err = json.Unmarshal(v, &s); err != nil {
+					return fmt.Errorf("unable to decode variables file %q: %w", file, err)
+				}
+				vars[k] = s
+			case '{', '[':
+				return fmt.Errorf("variables file %q: variable %s: expected a string, number, or boolean", file, k)
+			case 'n':
+				vars[k] = ""
+			default:
+				vars[k] = string(v)
+			}
+		}
+	} else {
+		bs := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; bs.Scan(); n++ {
+			line := strings.TrimSpace(bs.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+			name = strings.TrimSpace(name)
+			if !ok || !GnobvarName.MatchString(name) {
+				return fmt.Errorf("variables file %q: line %d: expected KEY=value", file, n)
+			}
+			value = strings.TrimSpace(value)
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+				value = value[1 : len(value)-1]
+			}
+			vars[name] = value
+		}
+		if err = bs.Err(); err != nil {
+			return fmt.Errorf("unable to read variables file %q: %w", file, err)
+		}
+	}
+	GnobLogger.Debug("[gnob:makefile] loaded variables", "file", file, "count", len(vars))
+	mf.fileVars = vars
+	return nil
+}
+
+// lookupVar returns the value of the variable with the given name, and where it comes from:
+// "command line", "environment", "file", or "default".
+// It returns false if the variable is not set and not declared.
+func (mf *GnobMakefile) lookupVar(name string) (string, string, bool) {
+	if v, ok := mf.cmdVars[name]; ok {
+		return v, "command line", true
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v, "environment", true
+	}
+	if v, ok := mf.fileVars[name]; ok {
+		return v, "file", true
+	}
+	for _, v := range mf.vars {
+		if v.Name == name {
+			return v.Default, "default", true
+		}
+	}
+	return "", "", false
+}
+
+// Var returns the value of the variable with the given name, or an empty string if it is not set.
+func (mf *GnobMakefile) Var(name string) string {
+	v, _, _ := mf.lookupVar(name)
+	return v
+}
+
+// VarBool returns the value of the variable with the given name as a boolean, like "true" or "1".
+// It returns false if the variable is not set, or is not a boolean.
+func (mf *GnobMakefile) VarBool(name string) bool {
+	s := mf.Var(name)
+	if s == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		GnobLogger.Warn("[gnob:makefile] variable is not a boolean", "name", name, "value", s)
+	}
+	return b
+}
+
+// VarInt returns the value of the variable with the given name as an integer.
+// It returns 0 if the variable is not set, or is not an integer.
+func (mf *GnobMakefile) VarInt(name string) int {
+	s := mf.Var(name)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		GnobLogger.Warn("[gnob:makefile] variable is not an integer", "name", name, "value", s)
+	}
+	return n
+}
+
+// VarDuration returns the value of the variable with the given name as a duration, like "1m30s".
+// It returns 0 if the variable is not set, or is not a duration.
+func (mf *GnobMakefile) VarDuration(name string) time.Duration {
+	s := mf.Var(name)
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		GnobLogger.Warn("[gnob:makefile] variable is not a duration", "name", name, "value", s)
+	}
+	return d
+}
+
+// Vars returns the values of the declared variables, and of the variables set on the command line or in the
+// variables file. It can be passed to Cmd.WithEnvVars, to make the variables available to commands.
+func (mf *GnobMakefile) Vars() map[string]string {
+	vars := make(map[string]string)
+	for _, v := range mf.vars {
+		vars[v.Name] = mf.Var(v.Name)
+	}
+	for name := range mf.fileVars {
+		vars[name] = mf.Var(name)
+	}
+	for name, v := range mf.cmdVars {
+		vars[name] = v
+	}
+	return vars
+}
+
+// showVars prints the declared variables with their value, where the value comes from, and their description.
+func (mf *GnobMakefile) showVars() {
+	if len(mf.vars) == 0 {
+		return
+	}
+	fmt.Println("Variables:")
+	entries := make([]string, 0, len(mf.vars))
+	maxLen := 0
+	for _, v := range mf.vars {
+		value, source, _ := mf.lookupVar(v.Name)
+		entry := v.Name + "=" + value
+		if source != "default" {
+			entry += " (" + source + ")"
+		}
+		entries = append(entries, entry)
+		maxLen = max(maxLen, len(entry))
+	}
+	for i, v := range mf.vars {
+		fmt.Printf("  %-*s   %s\n", maxLen, entries[i], v.Usage)
+	}
+}
+
+// WatchInterval is how often the files are checked for changes in watch mode, with `gnob -watch <target>`.
+// A run starts once the files have not changed for an interval, so that a burst of changes starts a single run.
+var GnobWatchInterval = 500 * time.Millisecond
+
+// watchFiles records files, which may be glob patterns, that the executing target is built from.
+// They are watched in watch mode, like the Inputs of the target.
+// It is called by the up-to-date functions of this package.
+func (mf *GnobMakefile) watchFiles(files ...string) {
+	if mf.run != nil {
+		mf.run.watched = append(mf.run.watched, files...)
+	}
+}
+
+// watchSet is the files watched for changes in watch mode.
+// The outputs are never watched, so that the targets do not trigger a new run by building them.
+type GnobwatchSet struct {
+	inputs  []string
+	outputs []string
+}
+
+// fileStamp is the state of a file used to detect changes.
+type GnobfileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchSet returns the files to watch for the given targets.
+// They are the Inputs of the targets and their dependencies, and the files given to the up-to-date functions
+// of the targets executed during the last run.
+func (mf *GnobMakefile) watchSet(targets []*GnobMakeTarget) GnobwatchSet {
+	var (
+		ws      GnobwatchSet
+		visited = make(map[*GnobMakeTarget]bool)
+		visit   func(tgt *GnobMakeTarget)
+	)
+	visit = func(tgt *GnobMakeTarget) {
+		if tgt == nil || visited[tgt] {
+			return
+		}
+		visited[tgt] = true
+		ws.inputs = append(ws.inputs, tgt.Inputs...)
+		ws.outputs = append(ws.outputs, tgt.Outputs...)
+		for _, dep := range tgt.Deps {
+			visit(mf.Find(dep))
+		}
+	}
+	for _, tgt := range targets {
+		visit(tgt)
+	}
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	for tgt, run := range mf.runs {
+		ws.inputs = append(ws.inputs, tgt.Inputs...)
+		ws.inputs = append(ws.inputs, run.watched...)
+		ws.outputs = append(ws.outputs, tgt.Outputs...)
+	}
+	return ws
+}
+
+// snapshot returns the state of the watched files.
+func (ws GnobwatchSet) snapshot() map[string]GnobfileStamp {
+	outputs := make(map[string]bool)
+	for _, pattern := range ws.outputs {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			outputs[m] = true
+		}
+	}
+	files := make(map[string]GnobfileStamp)
+	for _, pattern := range ws.inputs {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			if outputs[m] {
+				continue
+			}
+			if fi, err := os.Stat(m); err == nil {
+				files[m] = GnobfileStamp{modTime: fi.ModTime(), size: fi.Size()}
+			}
+		}
+	}
+	return files
+}
+
+// changedFile returns the first file, in lexical order, that was added, removed, or modified between two snapshots.
+// It returns an empty string if no file changed.
+func GnobchangedFile(before, after map[string]GnobfileStamp) string {
+	var changed []string
+	for name, stamp := range after {
+		if old, ok := before[name]; !ok || !old.modTime.Equal(stamp.modTime) || old.size != stamp.size {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+	return slices.Min(changed)
+}
+
+// resetRuns forgets the targets executed during the last run, so that they are executed again.
+func (mf *GnobMakefile) resetRuns() {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	mf.runs = nil
+	mf.edges = nil
+	mf.finished = nil
+}
+
+// watch executes the targets, and executes them again each time the files they are built from change,
+// until the context is cancelled.
+// A change during a run cancels the context of the run, and a new run starts once the files stop changing.
+// The summary of each run is printed, and failed runs are logged without stopping watch mode.
+func (mf *GnobMakefile) watch(ctx context.Context, targets []*GnobMakeTarget) error {
+	names := make([]string, 0, len(targets))
+	for _, tgt := range targets {
+		names = append(names, tgt.Name)
+	}
+	ws := mf.watchSet(targets)
+	snap := ws.snapshot()
+	ticker := time.NewTicker(GnobWatchInterval)
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- mf.runTargets(runCtx, targets)
+		}()
+		var changed string
+		running, quiet := true, false
+		for running || changed == "" || !quiet {
+			select {
+			case <-ctx.Done():
+				cancel()
+				if running {
+					<-done
+				}
+				return nil
+			case err := <-done:
+				running = false
+				if err != nil && !errors.Is(err, context.Canceled) {
+					GnobLogger.Error("[gnob:watch] error running build target", "error", err)
+				}
+				mf.showSummary()
+				next := mf.watchSet(targets)
+				if file := GnobchangedFile(snap, ws.snapshot()); file != "" && changed == "" {
+					changed = file
+				}
+				ws = next
+				snap = ws.snapshot()
+				mf.resetRuns()
+				GnobLogger.Debug("[gnob:watch] watching files", "files", len(snap))
+			case <-ticker.C:
+				next := ws.snapshot()
+				file := GnobchangedFile(snap, next)
+				snap = next
+				if file != "" {
+					if changed == "" {
+						changed = file
+					}
+					quiet = false
+					continue
+				}
+				quiet = changed != ""
+				if quiet && running && runCtx.Err() == nil {
+					GnobLogger.Info("[gnob:watch] cancelling the current run", "changed", changed)
+					cancel()
+				}
+			}
+		}
+		cancel()
+		_, _ = fmt.Fprintf(os.Stderr, "\n===== [gnob] %s changed, running %s again (run %d) =====\n\n",
+			changed, strings.Join(names, " "), n+1)
+	}
+}
@@ -350,5 +350,256 @@
 // Default Target
 // ```
 // 
+// When no target is given on the command line, the target with `Default: true` is executed.
+// If there is none, gnob shows a numbered menu of the targets that are not hidden when running in a terminal,
+// from which a target is picked by number, or by typing part of its name or description to filter the menu.
+// Otherwise, it fails with the list of targets instead of guessing.
 // 
+// #### Dependencies
+// 
+// Dependencies between targets can be declared with the `Deps` field of a `GnobMakeTarget`.
+// They are executed in order before the target's `UpToDate` function is checked.
+// Unknown dependencies and dependency cycles are reported as an error before any target runs,
+// along with targets that share a name, several targets with `Default: true`, and targets without a `Body`.
+// The same checks are available from `mf.Validate()`, which reports all the problems together.
+// Unknown targets, on the command line or in `Deps`, are reported with the closest target names,
+// like `unknown target: tset (did you mean test?)`.
+// 
+// ```go
+// GnobMakeTarget{
+// 	Name: "all",
+// 	Deps: []string{"build", "test"},
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		return nil
+// 	},
+// }
+// ```
+// 
+// Dependencies can also be executed from within the `Body` of a target with `mf.Depend`,
+// or concurrently with `mf.DependParallel`. The number of concurrent targets, counted across all the calls
+// to `mf.DependParallel`, defaults to the number of CPUs, and can be changed with `gnob -j N <target>`.
+// A target waiting for its dependencies does not count against that limit.
+// 
+// Every target runs at most once per invocation of `gnob`, even if several targets depend on it.
+// 
+// Several targets can be run from a single invocation, like `gnob test example`.
+// Each target is followed by its own flags and arguments, and an argument that names a known target starts the next one.
+// Arguments after `--` always belong to the current target, as in `gnob run -- test`.
+// The targets are executed in order, or concurrently with `gnob -parallel test example`.
+// 
+// The dependency graph can be exported in the Graphviz DOT format or as JSON with `gnob -graph[=dot|json] [target]`.
+// When a target is given, it is executed first, so that the dependencies it takes with `mf.Depend` are included in the graph.
+// The graph is also available programmatically from `mf.Graph()`.
+// 
+// #### Pattern Targets
+// 
+// Targets whose names follow a pattern can be declared once with a `GnobPatternTarget`,
+// like the `%.o: %.c` rules of Make. The `Name` contains a single `%`, which matches any non-empty stem.
+// The target is created when it is first referenced, with every `%` in its `Deps`, `Inputs`, and `Outputs`
+// replaced by the stem, and the stem is passed to its `Body`.
+// If several patterns match, the one with the shortest stem is used.
+// 
+// ```go
+// mf.AddPattern(GnobPatternTarget{
+// 	Name:    "bin/%",
+// 	Inputs:  []string{"cmd/%/*.go"},
+// 	Outputs: []string{"bin/%"},
+// 	Body: func(ctx context.Context, mf *GnobMakefile, stem string) error {
+// 		return GnobLib.Cmd.Exec(ctx, "go", "build", "-o", "bin/"+stem, "./cmd/"+stem).Run()
+// 	},
+// })
+// ```
+// 
+// #### Namespaces
+// 
+// Target names can be qualified with a namespace, like `docker:build` or `go:test`,
+// and `gnob -help` lists the targets of each namespace under their own heading.
+// A target can be referred to by its short name, like `gnob lint` for `go:lint`, as long as only one target has it;
+// otherwise the fully-qualified name is required.
+// 
+// A `GnobMakefile` can be mounted inside another under a namespace with `Mount`, which is useful to split a large build
+// into several files. The targets of the mounted Makefile keep referring to each other by their own names.
+// 
+// ```go
+// docker := GnobLib.Makefile.New(
+// 	GnobMakeTarget{Name: "build", Body: dockerBuild},
+// 	GnobMakeTarget{Name: "push", Deps: []string{"build"}, Body: dockerPush},
+// )
+// mf := GnobLib.Makefile.New(targets...)
+// mf.Mount("docker", docker) // adds docker:build and docker:push
+// mf.Run(ctx)
+// ```
+// 
+// #### Subprojects
+// 
+// Another gnob project in a subdirectory, like the `build/` directory of a component, can be used with
+// `GnobLib.Makefile.Project(dir)`. Its `Run` method builds the gnob binary of the project if any of its sources
+// is newer, like `RebuildYourself`, and executes it in the directory of the project.
+// `mf.Import` adds the targets of the project under a namespace, so that `gnob -help` lists them,
+// and `gnob examples/docs:test` runs `gnob test` in `examples/docs`.
+// The arguments of an imported target are passed on to the gnob binary of the project.
+// 
+// ```go
+// if err := mf.Import(ctx, "examples/docs", GnobLib.Makefile.Project("examples/docs")); err != nil {
+// 	GnobLogger.Error("unable to import examples/docs", "error", err)
+// 	os.Exit(1)
+// }
+// ```
+// 
+// #### Target Flags
+// 
+// Targets can declare the flags they accept on the command line with the `Flags` field,
+// using `GnobLib.Makefile.BoolFlag`, `StringFlag`, `IntFlag`, or `DurationFlag`.
+// They are parsed from the arguments following the target, like `gnob build -race -tags=foo`,
+// and their values are read from the `Body` with `mf.Bool`, `mf.String`, `mf.Int`, and `mf.Duration`.
+// Invalid or unknown flags are reported as an error, and the flags are listed by `gnob -help <target>`.
+// The remaining arguments are returned by `mf.TargetArgs()`. The arguments of a target without `Flags` are not
+// parsed, so `gnob test -v ./...` passes `-v ./...` to it as they are.
+// 
+// ```go
+// GnobMakeTarget{
+// 	Name: "build",
+// 	Flags: []GnobTargetFlag{
+// 		GnobLib.Makefile.BoolFlag("race", false, "enable the race detector"),
+// 		GnobLib.Makefile.StringFlag("tags", "", "comma-separated list of build tags"),
+// 	},
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		args := []string{"build", "-tags", mf.String("tags")}
+// 		if mf.Bool("race") {
+// 			args = append(args, "-race")
+// 		}
+// 		return GnobLib.Cmd.Exec(ctx, "go", append(args, "./...")...).Run()
+// 	},
+// }
+// ```
+// 
+// #### Timeouts and Retries
+// 
+// A target can limit how long its `Body` may take with `Timeout`, and be retried when it fails with `Retries`.
+// The context passed to the `Body` is cancelled when the timeout expires, and the target fails with
+// an error matching `GnobErrTargetTimeout`, which is reported as `timeout` in the summary.
+// Retries wait for `RetryBackoff`, one second by default, doubling the delay after each retry.
+// 
+// ```go
+// GnobMakeTarget{
+// 	Name:         "download",
+// 	Timeout:      30 * time.Second,
+// 	Retries:      3,
+// 	RetryBackoff: 2 * time.Second,
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		return GnobLib.Cmd.Exec(ctx, "curl", "-fsSLO", "https://example.com/archive.tar.gz").Run()
+// 	},
+// }
+// ```
+// 
+// #### Lifecycle Hooks
+// 
+// Notifications, metrics, and custom logging can be plugged into a Makefile without wrapping every `Body`,
+// by registering hooks with `mf.OnTargetStart`, `mf.OnTargetFinish`, `mf.OnUpToDate`, and `mf.OnError`,
+// which receive a `GnobTargetEvent` with the target, its result, duration, and error,
+// and with `mf.BeforeRun` and `mf.AfterRun`, which are called around the whole run.
+// The logging of the targets is itself done with hooks.
+// 
+// ```go
+// mf.OnError(func(ctx context.Context, ev GnobTargetEvent) {
+// 	notify(fmt.Sprintf("%s failed after %s: %v", ev.Target.Name, ev.Duration, ev.Err))
+// })
+// ```
+// 
+// #### Variables
+// 
+// Like `make build GOOS=linux`, variables are set with `KEY=value` arguments on the command line,
+// and read from the `Body` of a target with `mf.Var`, `mf.VarBool`, `mf.VarInt`, and `mf.VarDuration`.
+// A variable that is not set on the command line is taken from the environment, then from the variables file,
+// and finally from the `Default` declared with `mf.AddVars`. Declared variables are listed by `gnob -help`
+// with their current value. The variables file is `gnob.env`, with `KEY=value` lines, or `gnob.json`,
+// with a JSON object of strings, numbers, and booleans, unless another file is given with `-vars file`.
+// 
+// ```go
+// mf.AddVars(GnobMakeVar{Name: "GOOS", Default: "linux", Usage: "target operating system"})
+// ```
+// 
+// ```shell
+// ./gnob build GOOS=windows
+// ```
+// 
+// #### Command Line
+// 
+// Global flags are given before the targets, with either one or two dashes:
+// 
+//   - `-help`, `-h`: show the targets, or the help of the given target
+//   - `-v`, `-q`: show debug messages, or only warnings and errors, overriding `GNOB_LOG_LEVEL`
+//   - `-C dir`: change to `dir` before running the targets
+//   - `-j N`: maximum number of targets executed at the same time
+//   - `-parallel`: execute the targets given on the command line concurrently
+//   - `-n`: print the targets that would run, in order, without executing them
+//   - `-k`: keep going after a target fails, skipping only the targets that depend on it,
+//     instead of stopping at the first error
+//   - `-summary-json file`: write the summary of the run as JSON to `file`, for CI dashboards
+//   - `-trace file`: write a trace of the targets, the commands they execute, and the rebuild of gnob to `file`,
+//     in the Chrome trace-event format that can be loaded in [Perfetto](https://ui.perfetto.dev)
+//   - `-vars file`: read the variables from `file` instead of `gnob.env` or `gnob.json`
+//   - `-list[=json]`: list the targets, one per line with their description, or as JSON
+//   - `-graph[=dot|json]`: show the dependency graph
+//   - `-watch`: execute the targets again each time the files they are built from change
+//   - `-completion shell`: print the completion script for `bash`, `zsh`, or `fish`
+//   - `-gen-docs=markdown|man|file`: print the documentation of the targets as Markdown, as a man page,
+//     or with the template `file`
+// 
+// When the run finishes, a summary of every executed target is printed with its result
+// (success, failure, skipped, or up-to-date) and wall time, followed by the critical path:
+// the chain of dependencies that determined how long the run took.
+// The summary is also available programmatically from `mf.Summary()`.
+// 
+// In dry-run mode, with `gnob -n <target>`, the `UpToDate` functions are evaluated, but the `Body` of a target is
+// only called if its `EchoCommands` field is true. Like `make -n`, a target whose dependencies would run is printed
+// as one that would run too, even if its files are up-to-date. The commands such a target executes with `GnobLib.Cmd.Exec`
+// are printed, shell-quoted, instead of executed. The same can be done outside of a Makefile with
+// `GnobLib.Cmd.DryRun(ctx, os.Stdout)`.
+// 
+// Shell completion of the targets, the global flags, and the flags of each target is enabled by loading
+// the output of `-completion` in the shell, like `source <(./gnob -completion bash)`.
+// The scripts ask gnob for the candidates each time, with the hidden `__complete` command,
+// so they stay in sync with the targets of the current build file.
+// 
+// In watch mode, with `gnob -watch <target>`, the files the targets are built from are checked for changes every
+// `GnobWatchInterval`: the `Inputs` of the targets and their dependencies, and the files given to `FileUpToDate`,
+// `HashUpToDate`, and `StateUpToDate`. The `Outputs` of the targets are not watched. When a file changes,
+// the context of the current run is cancelled, and the targets are executed again once the files stop changing,
+// after a separator line. This replaces loops with tools like `entr`.
+// 
+// The documentation generated with `-gen-docs` lists every target that is not hidden, with its description,
+// dependencies, flags, and whether it is the default target. It is also available from `mf.WriteDocs(w, format)`,
+// and `mf.Docs()` returns the `GnobMakeDocs` that the templates are executed with. A different layout is used
+// by giving the path of a template file, or a template parsed with `GnobLib.Template` to `mf.WriteDocsTemplate`.
+// 
+// #### Up-to-date Checks
+// 
+// A target is skipped when its `UpToDate` function returns true.
+// `GnobLib.Makefile.FileUpToDate(target, sources...)` compares modification times,
+// while `GnobLib.Makefile.HashUpToDate(target, sources...)` compares the SHA-256 digests of the file contents,
+// so it is not fooled by `git checkout`, restored CI caches, or copied files.
+// The digests are stored in the `.gnob/` directory, which should be ignored by version control.
+// 
+// Instead of an `UpToDate` function, a target that builds files can declare its `Inputs` and `Outputs`,
+// which may be glob patterns. The target is skipped when every output exists and is newer than all the inputs,
+// and it fails if one of its outputs is missing after its `Body` succeeds.
+// The inputs and outputs are listed by `gnob -help <target>`.
+// 
+// ```go
+// GnobMakeTarget{
+// 	Name:    "bin/app",
+// 	Inputs:  []string{"go.mod", "*.go"},
+// 	Outputs: []string{"bin/app"},
+// 	Body: func(ctx context.Context, mf *GnobMakefile) error {
+// 		return GnobLib.Cmd.Exec(ctx, "go", "build", "-o", "bin/app", ".").Run()
+// 	},
+// }
+// ```
+// 
+// Targets that do not produce a single file, like running tests, can use
+// `GnobLib.Makefile.StateUpToDate(inputs, outputs)`. It records the last run time, duration, input digests,
+// outputs, and result of the target in the `.gnob/state` database, and skips the target
+// if its last run succeeded and none of its inputs have changed since.
+// The recorded state can be queried with `mf.State(name)`.
 package gnoblib
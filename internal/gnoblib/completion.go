@@ -0,0 +1,113 @@
+package gnoblib
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// completeCommand is the hidden command that the completion scripts execute to complete the command line,
+// like `gnob __complete -j 4 bu`. The last argument is the word being completed, and may be empty.
+const completeCommand = "__complete"
+
+// completionScripts are the completion scripts for each shell, printed by `gnob -completion <shell>`.
+// They are formatted with the name of the command, and the name of the command usable as a shell identifier.
+var completionScripts = map[string]string{
+	"bash": `_%[2]s_completion() {
+	local line="${COMP_LINE:0:COMP_POINT}" words
+	read -ra words <<< "$line"
+	[[ "$line" == *" " ]] && words+=("")
+	local cur="${words[-1]}" prefix=""
+	[[ "$cur" == *:* && "$COMP_WORDBREAKS" == *:* ]] && prefix="${cur%%"${cur##*:}"}"
+	local IFS=$'\n' c
+	COMPREPLY=()
+	for c in $("${words[0]}" __complete "${words[@]:1}" 2>/dev/null); do
+		COMPREPLY+=("${c#"$prefix"}")
+	done
+}
+complete -o default -F _%[2]s_completion %[1]s ./%[1]s
+`,
+	"zsh": `#compdef %[1]s ./%[1]s
+_%[2]s_completion() {
+	local -a candidates
+	candidates=(${(f)"$(${words[1]} __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ${#candidates} )); then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _%[2]s_completion %[1]s ./%[1]s
+`,
+	"fish": `function __%[2]s_completion
+	set -l tokens (commandline -opc) (commandline -ct)
+	$tokens[1] __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c %[1]s -c ./%[1]s -a '(__%[2]s_completion)'
+`,
+}
+
+// showCompletion prints the completion script for the given shell.
+func (mf *Makefile) showCompletion(shell string) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unknown shell for completion: %s", shell)
+	}
+	name := filepath.Base(mf.name)
+	ident := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	fmt.Printf(script, name, ident)
+	return nil
+}
+
+// showCompletions prints the candidates for the last word of the command line, one per line.
+// Words starting with a dash are completed with the global flags before the first target,
+// and with the flags of the target after it. Other words are completed with the names of the targets
+// that are not hidden, and nothing is printed after `--`, or when the word is the value of a flag.
+func (mf *Makefile) showCompletions(words []string) error {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	fs, _ := mf.optionSet()
+	for i := 0; i < len(words)-1; i++ {
+		word := words[i]
+		switch {
+		case word == "--":
+			return nil
+		case strings.HasPrefix(word, "-") && len(word) > 1:
+			if takesValue(fs, word) {
+				if i++; i == len(words)-1 {
+					return nil
+				}
+			}
+		default:
+			if tgt := mf.Find(word); tgt != nil {
+				fs = tgt.flagSet()
+			}
+		}
+	}
+	if strings.HasPrefix(cur, "-") {
+		dashes := "-"
+		if strings.HasPrefix(cur, "--") {
+			dashes = "--"
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			if strings.HasPrefix(f.Name, strings.TrimLeft(cur, "-")) {
+				fmt.Println(dashes + f.Name)
+			}
+		})
+		return nil
+	}
+	for _, tgt := range mf.targets {
+		if !tgt.Hidden && strings.HasPrefix(tgt.Name, cur) {
+			fmt.Println(tgt.Name)
+		}
+	}
+	return nil
+}
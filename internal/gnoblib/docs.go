@@ -0,0 +1,209 @@
+package gnoblib
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// MakeDocs is the documentation of the targets of a Makefile, which is the data of the documentation templates.
+type MakeDocs struct {
+	// Name is the name of the program.
+	Name string
+	// Flags are the global flags of the Makefile.
+	Flags []MakeDocsFlag
+	// Targets are the targets and pattern targets that are not hidden, sorted by name, the default target first.
+	Targets []MakeDocsTarget
+}
+
+// MakeDocsTarget is the documentation of a target in MakeDocs.
+type MakeDocsTarget struct {
+	// Name is the name of the target.
+	Name string
+	// Desc is the short description of the target.
+	Desc string
+	// LongDesc is the long description of the target.
+	LongDesc string
+	// Default is true if the target is the default target.
+	Default bool
+	// Pattern is true if the target is a pattern target.
+	Pattern bool
+	// Deps are the names of the targets the target depends on.
+	Deps []string
+	// Flags are the flags the target accepts on the command line.
+	Flags []MakeDocsFlag
+}
+
+// MakeDocsFlag is the documentation of a flag in MakeDocs.
+type MakeDocsFlag struct {
+	// Name is the name of the flag, without the leading dash.
+	Name string
+	// Usage is the description of the flag.
+	Usage string
+	// Default is the default value of the flag, as text.
+	Default string
+}
+
+// docsTemplates are the built-in templates of WriteDocs, by format.
+var docsTemplates = map[string]string{
+	"markdown": `# {{ .Name }}
+
+Run ` + "`{{ .Name }} <target>`" + ` to execute a target.
+{{- range .Targets }}
+
+## ` + "`{{ .Name }}`" + `{{ if .Default }} (default){{ end }}{{ if .Pattern }} (pattern){{ end }}
+{{- with .Desc }}
+
+{{ . }}
+{{- end }}
+{{- with .LongDesc }}
+
+{{ . }}
+{{- end }}
+{{- with .Deps }}
+
+Dependencies: {{ range $i, $dep := . }}{{ if $i }}, {{ end }}` + "`{{ $dep }}`" + `{{ end }}
+{{- end }}
+{{- with .Flags }}
+
+| Flag | Default | Description |
+| ---- | ------- | ----------- |
+{{- range . }}
+| ` + "`-{{ .Name }}`" + ` | {{ with .Default }}` + "`{{ . }}`" + `{{ end }} | {{ .Usage }} |
+{{- end }}
+{{- end }}
+{{- end }}
+`,
+	"man": `.TH {{ upper .Name }} 1
+.SH NAME
+{{ man .Name }} \- build targets
+.SH SYNOPSIS
+.B {{ man .Name }}
+[\fIflags\fR] [\fItarget\fR [\fIflags\fR] [\fIargs\fR]...]
+.SH OPTIONS
+{{- range .Flags }}
+.TP
+.B \-{{ man .Name }}
+{{ man .Usage }}
+{{- end }}
+.SH TARGETS
+{{- range .Targets }}
+.TP
+.B {{ man .Name }}{{ if .Default }} (default){{ end }}{{ if .Pattern }} (pattern){{ end }}
+{{- with .Desc }}
+{{ man . }}
+{{- end }}
+{{- with .LongDesc }}
+.IP
+{{ man . }}
+{{- end }}
+{{- with .Deps }}
+.IP
+Dependencies: {{ man (join . ", ") }}
+{{- end }}
+{{- range .Flags }}
+.IP
+\fB\-{{ man .Name }}\fR{{ with .Default }} (default {{ man . }}){{ end }}: {{ man .Usage }}
+{{- end }}
+{{- end }}
+`,
+}
+
+// docsFuncs are the template functions of the documentation templates, in addition to those of Template.
+func docsFuncs() template.FuncMap {
+	return template.FuncMap{
+		"join":  strings.Join,
+		"upper": strings.ToUpper,
+		// man escapes the text for a man page
+		"man": func(s string) string {
+			lines := strings.Split(strings.ReplaceAll(s, `\`, `\e`), "\n")
+			for i, line := range lines {
+				if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+					lines[i] = `\&` + line
+				}
+			}
+			return strings.Join(lines, "\n")
+		},
+	}
+}
+
+// docsFlags returns the documentation of the flags of the flag set.
+func docsFlags(fs *flag.FlagSet) []MakeDocsFlag {
+	var flags []MakeDocsFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, MakeDocsFlag{Name: f.Name, Usage: f.Usage, Default: f.DefValue})
+	})
+	return flags
+}
+
+// Docs returns the documentation of the targets of the Makefile.
+func (mf *Makefile) Docs() *MakeDocs {
+	fs, _ := mf.optionSet()
+	docs := &MakeDocs{
+		Name:  filepath.Base(mf.name),
+		Flags: docsFlags(fs),
+	}
+	for i, tgt := range mf.targets {
+		if tgt.Hidden {
+			continue
+		}
+		docs.Targets = append(docs.Targets, MakeDocsTarget{
+			Name:     tgt.Name,
+			Desc:     tgt.Desc,
+			LongDesc: tgt.LongDesc,
+			Default:  i == mf.defaultTarget,
+			Deps:     tgt.Deps,
+			Flags:    docsFlags(tgt.flagSet()),
+		})
+	}
+	for _, pt := range mf.patterns {
+		if pt.Hidden {
+			continue
+		}
+		docs.Targets = append(docs.Targets, MakeDocsTarget{
+			Name:     pt.Name,
+			Desc:     pt.Desc,
+			LongDesc: pt.LongDesc,
+			Pattern:  true,
+			Deps:     pt.Deps,
+			Flags:    docsFlags((&MakeTarget{Name: pt.Name, Flags: pt.Flags}).flagSet()),
+		})
+	}
+	return docs
+}
+
+// WriteDocs writes the documentation of the targets of the Makefile to w, in the given format.
+// The format is either markdown, man for a man page, or the path of a template file to use another layout.
+// Templates are parsed with Template, and executed with the MakeDocs of the Makefile.
+// In addition to the functions of Template, they can use `join`, `upper`, and `man`, which escapes text for a man page.
+// This can also be done on the command line with `gnob -gen-docs=markdown`.
+func (mf *Makefile) WriteDocs(w io.Writer, format string) error {
+	var (
+		t   _template
+		tpl *template.Template
+		err error
+	)
+	if text, ok := docsTemplates[format]; ok {
+		tpl, err = t.ParseTextFuncs(text, docsFuncs())
+	} else if _, statErr := os.Stat(format); statErr == nil {
+		tpl, err = t.ParseFileFuncs(format, docsFuncs())
+	} else {
+		return fmt.Errorf("unknown documentation format: %s", format)
+	}
+	if err != nil {
+		return err
+	}
+	return mf.WriteDocsTemplate(w, tpl)
+}
+
+// WriteDocsTemplate writes the documentation of the targets of the Makefile to w, using the given template.
+func (mf *Makefile) WriteDocsTemplate(w io.Writer, tpl *template.Template) error {
+	if err := tpl.Execute(w, mf.Docs()); err != nil {
+		return fmt.Errorf("unable to write documentation: %w", err)
+	}
+	return nil
+}
@@ -9,6 +9,9 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
 // ExecOption is the interface for options to customize the command.
@@ -109,11 +112,35 @@ type Exec struct {
 	closers   []io.Closer
 	onExit    []func()
 	exitCodes []int
+	dryRun    bool
+	span      *traceSpan
 }
 
 type _cmd struct {
 }
 
+type dryRunKey struct{}
+
+// DryRun returns a context in which the commands created with Exec are printed to w, shell-quoted,
+// instead of being executed.
+// This is used by the Makefile in dry-run mode.
+func (_cmd) DryRun(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, w)
+}
+
+// shellQuote returns the arguments quoted for a POSIX shell.
+func shellQuote(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
 // Exec creates a new command.
 // The output of this command can be chained into other commands with Pipe and Pipe2.
 // For example:
@@ -237,20 +264,27 @@ func (e *Exec) Run() error {
 // Start starts the command chain.
 // It returns the first error encountered.
 // It does not wait for the command to finish, to wait for the command to finish, use Wait.
+// If the context was created with DryRun, the command chain is printed instead of started.
 func (e *Exec) Start() error {
+	if w, ok := e.ctx.Value(dryRunKey{}).(io.Writer); ok {
+		return e.printDryRun(w)
+	}
 	this := e
 	if this.cmd.Stderr == nil {
 		this.cmd.Stderr = &this.stderr
 	} else {
 		this.cmd.Stderr = io.MultiWriter(&this.stderr, e.cmd.Stderr)
 	}
-	var chain []*exec.Cmd
+	var chain []*Exec
 	for this != nil {
-		chain = append(chain, this.cmd)
+		chain = append(chain, this)
 		this = this.prev
 	}
 	for i := len(chain) - 1; i >= 0; i-- {
-		if err := chain[i].Start(); err != nil {
+		c := chain[i]
+		c.span = traces.start("command", filepath.Base(c.cmd.Path), map[string]any{"cmd": shellQuote(c.cmd.Args)})
+		if err := c.cmd.Start(); err != nil {
+			c.span.end(err)
 			return err
 		}
 	}
@@ -262,6 +296,9 @@ func (e *Exec) Start() error {
 // To get the exit code of the last command, use ExitCode.
 // To get the exit codes of all commands, use ExitCodes.
 func (e *Exec) Wait() error {
+	if e.dryRun {
+		return nil
+	}
 	this := e
 	var chain []*Exec
 	for this != nil {
@@ -276,7 +313,9 @@ func (e *Exec) Wait() error {
 			for _, c := range chain[i].closers {
 				_ = c.Close()
 			}
-			errCh <- chain[i].cmd.Wait()
+			err := chain[i].cmd.Wait()
+			chain[i].span.end(err)
+			errCh <- err
 		}()
 		select {
 		case <-e.ctx.Done():
@@ -301,6 +340,22 @@ func (e *Exec) Wait() error {
 	return nil
 }
 
+// printDryRun prints the command chain to w instead of starting it.
+func (e *Exec) printDryRun(w io.Writer) error {
+	var chain []string
+	for this := e; this != nil; this = this.prev {
+		line := shellQuote(this.cmd.Args)
+		if this.cmd.Dir != "" {
+			line = "cd " + shellQuote([]string{this.cmd.Dir}) + " && " + line
+		}
+		chain = append(chain, line)
+	}
+	slices.Reverse(chain)
+	e.dryRun = true
+	_, err := fmt.Fprintf(w, "  %s\n", strings.Join(chain, " | "))
+	return err
+}
+
 // ExitCode returns the exit code of the last command.
 func (e *Exec) ExitCode() int {
 	if len(e.exitCodes) == 0 {
@@ -1,6 +1,8 @@
 package gnoblib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -142,3 +144,40 @@ func (f _files) TargetNeedsUpdate(target string, sources ...string) bool {
 	b := f.LatestTimestamp(sources...)
 	return b.After(a)
 }
+
+// Digest returns the hex-encoded SHA-256 digest of the contents of the file.
+func (f _files) Digest(file string) (string, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file %q: %w", file, err)
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, fd); err != nil {
+		return "", fmt.Errorf("unable to read file %q: %w", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Digests expands glob patterns and returns the SHA-256 digests of all matched files, by path.
+// Directories matched by a pattern are skipped.
+func (f _files) Digests(files ...string) (map[string]string, error) {
+	digests := make(map[string]string)
+	for _, pattern := range files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("unable to expand glob %q: %w", pattern, err)
+		}
+		for _, p := range matches {
+			if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+				continue
+			}
+			digest, err := f.Digest(p)
+			if err != nil {
+				return nil, err
+			}
+			digests[p] = digest
+		}
+	}
+	return digests, nil
+}
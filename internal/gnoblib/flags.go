@@ -0,0 +1,195 @@
+package gnoblib
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// TargetFlag is a flag accepted by a MakeTarget on the command line, like `gnob build -race -tags=foo`.
+// Use BoolFlag, StringFlag, IntFlag, or DurationFlag to create one.
+type TargetFlag struct {
+	// Name is the name of the flag, without the leading dash.
+	Name string
+	// Usage is a short description of the flag.
+	// It is shown when running `gnob -help <target>`.
+	Usage string
+	// define adds the flag to a flag set.
+	define func(fs *flag.FlagSet)
+}
+
+// BoolFlag returns a boolean flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.Bool.
+func (_makefile) BoolFlag(name string, value bool, usage string) TargetFlag {
+	return TargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.Bool(name, value, usage)
+	}}
+}
+
+// StringFlag returns a string flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.String.
+func (_makefile) StringFlag(name string, value string, usage string) TargetFlag {
+	return TargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.String(name, value, usage)
+	}}
+}
+
+// IntFlag returns an integer flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.Int.
+func (_makefile) IntFlag(name string, value int, usage string) TargetFlag {
+	return TargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.Int(name, value, usage)
+	}}
+}
+
+// DurationFlag returns a duration flag for a MakeTarget.
+// The value can be read from the Body of the target with Makefile.Duration.
+func (_makefile) DurationFlag(name string, value time.Duration, usage string) TargetFlag {
+	return TargetFlag{Name: name, Usage: usage, define: func(fs *flag.FlagSet) {
+		fs.Duration(name, value, usage)
+	}}
+}
+
+// flagSet returns a flag set with the flags of the target, set to their default values.
+func (mt *MakeTarget) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(mt.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	for _, f := range mt.Flags {
+		if f.define == nil {
+			Logger.Warn("[gnob:makefile] ignoring flag without a type", "target", mt.Name, "flag", f.Name)
+			continue
+		}
+		f.define(fs)
+	}
+	return fs
+}
+
+// commandLine is the flags and arguments given to a target on the command line.
+type commandLine struct {
+	flags *flag.FlagSet
+	args  []string
+}
+
+// parseCommandLine parses the targets to run from the argument list, like `gnob build -race pkg test example`.
+// Each target is followed by its flags and arguments. The arguments of targets without Flags are not parsed as flags.
+// An argument that names a known target starts the next target,
+// unless it follows a `--`, after which all the arguments belong to the current target.
+// The `KEY=value` arguments before the `--` that are not flag values set variables, like `gnob build GOOS=linux`,
+// and can be followed by more flags.
+// The flags and arguments of each target are recorded so that they are available when it executes.
+func (mf *Makefile) parseCommandLine(args []string) ([]*MakeTarget, error) {
+	var targets []*MakeTarget
+	for len(args) > 0 {
+		if mf.parseVar(args[0]) {
+			args = args[1:]
+			continue
+		}
+		tgt, err := mf.lookup(args[0])
+		if err != nil {
+			return nil, err
+		}
+		fs := tgt.flagSet()
+		var targetArgs []string
+		// targets without flags get their arguments as they are, like `gnob test -v ./...`
+		rest, parse := args[1:], len(tgt.Flags) > 0
+	scan:
+		for len(rest) > 0 {
+			if parse {
+				if err := fs.Parse(rest); err != nil {
+					if errors.Is(err, flag.ErrHelp) {
+						return []*MakeTarget{tgt}, err
+					}
+					return nil, fmt.Errorf("target %s: %w", tgt.Name, err)
+				}
+				if consumed := len(rest) - fs.NArg(); consumed > 0 && rest[consumed-1] == "--" {
+					targetArgs = append(targetArgs, fs.Args()...)
+					rest = nil
+					break
+				}
+				rest, parse = fs.Args(), false
+			}
+			for len(rest) > 0 {
+				switch arg := rest[0]; {
+				case arg == "--":
+					targetArgs = append(targetArgs, rest[1:]...)
+					rest = nil
+				case mf.parseVar(arg):
+					rest = rest[1:]
+					// flags can follow a variable, like `gnob build GOOS=linux -race`
+					if len(tgt.Flags) > 0 {
+						parse = true
+						continue scan
+					}
+				case mf.Find(arg) != nil:
+					break scan
+				default:
+					targetArgs = append(targetArgs, arg)
+					rest = rest[1:]
+				}
+			}
+		}
+		mf.runsMu.Lock()
+		if mf.commandLines == nil {
+			mf.commandLines = make(map[*MakeTarget]commandLine)
+		}
+		mf.commandLines[tgt] = commandLine{flags: fs, args: slices.Clip(targetArgs)}
+		mf.runsMu.Unlock()
+		targets = append(targets, tgt)
+		args = rest
+	}
+	return targets, nil
+}
+
+// flagValue returns the value of the flag with the given name of the executing target.
+// It returns nil if the Makefile is not executing a target, or the target has no such flag.
+func (mf *Makefile) flagValue(name string) any {
+	if mf.run == nil || mf.run.cmd.flags == nil {
+		return nil
+	}
+	f := mf.run.cmd.flags.Lookup(name)
+	if f == nil {
+		Logger.Warn("[gnob:makefile] unknown flag", "target", mf.target.Name, "flag", name)
+		return nil
+	}
+	return f.Value.(flag.Getter).Get()
+}
+
+// Bool returns the value of the boolean flag with the given name of the executing target.
+func (mf *Makefile) Bool(name string) bool {
+	v, _ := mf.flagValue(name).(bool)
+	return v
+}
+
+// String returns the value of the string flag with the given name of the executing target.
+func (mf *Makefile) String(name string) string {
+	v, _ := mf.flagValue(name).(string)
+	return v
+}
+
+// Int returns the value of the integer flag with the given name of the executing target.
+func (mf *Makefile) Int(name string) int {
+	v, _ := mf.flagValue(name).(int)
+	return v
+}
+
+// Duration returns the value of the duration flag with the given name of the executing target.
+func (mf *Makefile) Duration(name string) time.Duration {
+	v, _ := mf.flagValue(name).(time.Duration)
+	return v
+}
+
+// showFlags prints the flags of the target.
+func (mt *MakeTarget) showFlags() {
+	if len(mt.Flags) == 0 {
+		return
+	}
+	fs := mt.flagSet()
+	fs.SetOutput(os.Stdout)
+	fmt.Println()
+	fmt.Println("Flags:")
+	fs.PrintDefaults()
+}
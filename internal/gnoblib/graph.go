@@ -0,0 +1,167 @@
+package gnoblib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// makeEdge is a dependency from one target to another that was taken during a run.
+type makeEdge struct {
+	from *MakeTarget
+	to   *MakeTarget
+}
+
+// MakeGraph is the dependency graph of the targets in a Makefile.
+type MakeGraph struct {
+	// Nodes are the targets of the Makefile.
+	Nodes []MakeGraphNode `json:"nodes"`
+	// Edges are the dependencies between the targets.
+	Edges []MakeGraphEdge `json:"edges"`
+}
+
+// MakeGraphNode is a target in the MakeGraph.
+type MakeGraphNode struct {
+	// Name is the name of the target.
+	Name string `json:"name"`
+	// Desc is the short description of the target.
+	Desc string `json:"desc,omitempty"`
+	// Hidden is true if the target is hidden from listing.
+	Hidden bool `json:"hidden"`
+	// Default is true if the target is the default target.
+	Default bool `json:"default"`
+	// UpToDate is true if the target is up-to-date, according to its UpToDate function,
+	// or otherwise to the modification times of its Inputs and Outputs.
+	// It is always false for targets without an UpToDate function or Outputs.
+	UpToDate bool `json:"upToDate"`
+}
+
+// MakeGraphEdge is a dependency between two targets in the MakeGraph.
+type MakeGraphEdge struct {
+	// From is the name of the dependent target.
+	From string `json:"from"`
+	// To is the name of the dependency.
+	To string `json:"to"`
+	// Dynamic is true if the dependency is not declared in Deps,
+	// but was recorded when the target called Depend during a run.
+	Dynamic bool `json:"dynamic"`
+}
+
+// Graph returns the dependency graph of the Makefile.
+// The graph contains the dependencies declared with Deps,
+// and the dependencies recorded from calls to Depend while the Makefile was running.
+// Targets created from pattern targets are included once they have been referenced.
+// Every target is checked for being up-to-date to populate the graph, like when it is executed.
+func (mf *Makefile) Graph() *MakeGraph {
+	targets := append(slices.Clip(mf.targets), mf.patternInstances()...)
+	g := &MakeGraph{
+		Nodes: make([]MakeGraphNode, 0, len(targets)),
+	}
+	static := make(map[makeEdge]struct{})
+	for i, tgt := range targets {
+		g.Nodes = append(g.Nodes, MakeGraphNode{
+			Name:     tgt.Name,
+			Desc:     tgt.Desc,
+			Hidden:   tgt.Hidden,
+			Default:  i == mf.defaultTarget,
+			UpToDate: tgt.upToDate(&Makefile{makefileState: mf.makefileState, target: tgt}),
+		})
+		for _, dep := range tgt.Deps {
+			to := mf.Find(dep)
+			if to == nil {
+				continue
+			}
+			static[makeEdge{from: tgt, to: to}] = struct{}{}
+			g.Edges = append(g.Edges, MakeGraphEdge{From: tgt.Name, To: to.Name})
+		}
+	}
+	mf.runsMu.Lock()
+	var dynamic []MakeGraphEdge
+	for e := range mf.edges {
+		if _, ok := static[e]; ok {
+			continue
+		}
+		dynamic = append(dynamic, MakeGraphEdge{From: e.from.Name, To: e.to.Name, Dynamic: true})
+	}
+	mf.runsMu.Unlock()
+	slices.SortFunc(dynamic, func(a, b MakeGraphEdge) int {
+		if c := strings.Compare(a.From, b.From); c != 0 {
+			return c
+		}
+		return strings.Compare(a.To, b.To)
+	})
+	g.Edges = append(g.Edges, dynamic...)
+	return g
+}
+
+// WriteJSON writes the graph as JSON to w.
+func (g *MakeGraph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language to w.
+// The default target is drawn in bold, hidden targets are dashed, and up-to-date targets are filled.
+// Dynamic dependencies are drawn as dashed edges.
+func (g *MakeGraph) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph gnob {\n")
+	sb.WriteString("  node [shape=box];\n")
+	for _, n := range g.Nodes {
+		var style []string
+		if n.Default {
+			style = append(style, "bold")
+		}
+		if n.Hidden {
+			style = append(style, "dashed")
+		}
+		if n.UpToDate {
+			style = append(style, "filled")
+		}
+		fmt.Fprintf(&sb, "  %q", n.Name)
+		if len(style) > 0 {
+			fmt.Fprintf(&sb, " [style=%q]", strings.Join(style, ","))
+		}
+		sb.WriteString(";\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&sb, "  %q -> %q", e.From, e.To)
+		if e.Dynamic {
+			sb.WriteString(" [style=dashed]")
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// showGraph prints the dependency graph in the given format.
+// If targets are given on the command line, they are executed first,
+// so that the dependencies they take with Depend are recorded in the graph.
+func (mf *Makefile) showGraph(ctx context.Context, format string) error {
+	var write func(g *MakeGraph, w io.Writer) error
+	switch format {
+	case "", "dot":
+		write = (*MakeGraph).WriteDOT
+	case "json":
+		write = (*MakeGraph).WriteJSON
+	default:
+		return fmt.Errorf("unknown graph format: %s", format)
+	}
+	targets, err := mf.parseCommandLine(mf.commandArgs)
+	if err != nil {
+		return err
+	}
+	for _, tgt := range targets {
+		if err = tgt.exec(ctx, mf); err != nil {
+			return err
+		}
+	}
+	return write(mf.Graph(), os.Stdout)
+}
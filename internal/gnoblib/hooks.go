@@ -0,0 +1,84 @@
+package gnoblib
+
+import (
+	"context"
+	"time"
+)
+
+// TargetEvent describes a target to the lifecycle hooks of a Makefile.
+type TargetEvent struct {
+	// Target is the target the event is about.
+	Target *MakeTarget
+	// Result is one of TargetSucceeded, TargetFailed, TargetTimedOut, or TargetUpToDate.
+	// It is empty when the target starts, and in dry-run mode.
+	Result string
+	// Duration is how long the target took, since it started.
+	Duration time.Duration
+	// Err is the error of the target, if it failed.
+	Err error
+}
+
+// TargetHook is a function called on a lifecycle event of a target.
+// Targets can execute concurrently, so hooks can be called concurrently too.
+type TargetHook func(ctx context.Context, ev TargetEvent)
+
+// makeHooks are the lifecycle hooks registered on a Makefile.
+type makeHooks struct {
+	targetStart  []TargetHook
+	targetFinish []TargetHook
+	upToDate     []TargetHook
+	onError      []TargetHook
+	beforeRun    []func(ctx context.Context, targets []*MakeTarget)
+	afterRun     []func(ctx context.Context, err error)
+}
+
+// OnTargetStart registers a hook that is called when a target starts, after its dependencies finished.
+func (mf *Makefile) OnTargetStart(fn TargetHook) {
+	mf.hooks.targetStart = append(mf.hooks.targetStart, fn)
+}
+
+// OnTargetFinish registers a hook that is called when a target that started finishes, whatever its result.
+func (mf *Makefile) OnTargetFinish(fn TargetHook) {
+	mf.hooks.targetFinish = append(mf.hooks.targetFinish, fn)
+}
+
+// OnUpToDate registers a hook that is called when a target is skipped because it is up-to-date.
+func (mf *Makefile) OnUpToDate(fn TargetHook) {
+	mf.hooks.upToDate = append(mf.hooks.upToDate, fn)
+}
+
+// OnError registers a hook that is called when a target fails or times out.
+func (mf *Makefile) OnError(fn TargetHook) {
+	mf.hooks.onError = append(mf.hooks.onError, fn)
+}
+
+// BeforeRun registers a hook that is called with the targets given on the command line, before they are executed.
+func (mf *Makefile) BeforeRun(fn func(ctx context.Context, targets []*MakeTarget)) {
+	mf.hooks.beforeRun = append(mf.hooks.beforeRun, fn)
+}
+
+// AfterRun registers a hook that is called with the result of the run, after all the targets finished.
+// The summary of the run is available from Summary.
+func (mf *Makefile) AfterRun(fn func(ctx context.Context, err error)) {
+	mf.hooks.afterRun = append(mf.hooks.afterRun, fn)
+}
+
+// emit calls the hooks with the event.
+func emit(ctx context.Context, hooks []TargetHook, ev TargetEvent) {
+	for _, fn := range hooks {
+		fn(ctx, ev)
+	}
+}
+
+// logHooks registers the hooks that log the lifecycle of the targets.
+func (mf *Makefile) logHooks() {
+	mf.OnTargetStart(func(ctx context.Context, ev TargetEvent) {
+		Logger.DebugContext(ctx, "[gnob:makefile] execute target", "target", ev.Target.Name)
+	})
+	mf.OnUpToDate(func(ctx context.Context, ev TargetEvent) {
+		Logger.InfoContext(ctx, "[gnob:makefile] target is up-to-date", "target", ev.Target.Name)
+	})
+	mf.OnError(func(ctx context.Context, ev TargetEvent) {
+		Logger.ErrorContext(ctx, "[gnob:makefile] error executing target", "target", ev.Target.Name, "error", ev.Err)
+	})
+}
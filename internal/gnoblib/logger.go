@@ -11,13 +11,20 @@ import (
 )
 
 var (
-	Logger = defaultLogger()
+	logLevel = new(slog.LevelVar)
+	Logger   = defaultLogger()
 )
 
 func SetLogger(logger *slog.Logger) {
 	Logger = logger
 }
 
+// SetLogLevel sets the minimum level of the messages written by the default Logger.
+// It overrides the level set by the GNOB_LOG_LEVEL environment variable.
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
 type _logHandler struct {
 	start  time.Time
 	output io.Writer
@@ -59,17 +66,17 @@ func (h *_logHandler) Handle(_ context.Context, r slog.Record) error {
 
 func defaultLogger() *slog.Logger {
 	opts := &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: logLevel,
 	}
 	switch os.Getenv(EnvLogLevel) {
 	case "debug":
-		opts.Level = slog.LevelDebug
+		logLevel.Set(slog.LevelDebug)
 	case "info":
-		opts.Level = slog.LevelInfo
+		logLevel.Set(slog.LevelInfo)
 	case "warn":
-		opts.Level = slog.LevelWarn
+		logLevel.Set(slog.LevelWarn)
 	case "error":
-		opts.Level = slog.LevelError
+		logLevel.Set(slog.LevelError)
 	}
 	return slog.New(
 		&_logHandler{
@@ -0,0 +1,127 @@
+package gnoblib
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// namespaceOf returns the namespace of a target name, which is everything before its last ':'.
+// For example, the namespace of `docker:build` is `docker`. Names without a ':' have no namespace.
+func namespaceOf(name string) string {
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// namespaces returns the namespaces of the visible targets and pattern targets, sorted by name.
+// Targets without a namespace come first.
+func (mf *Makefile) namespaces() []string {
+	namespaces := []string{""}
+	for _, tgt := range mf.targets {
+		if !tgt.Hidden {
+			namespaces = append(namespaces, namespaceOf(tgt.Name))
+		}
+	}
+	for _, pt := range mf.patterns {
+		if !pt.Hidden {
+			namespaces = append(namespaces, namespaceOf(pt.Name))
+		}
+	}
+	slices.Sort(namespaces)
+	return slices.Compact(namespaces)
+}
+
+// lookup returns the target with the given name, as described by Find.
+// It returns an error if the target is not found, or if the short name is ambiguous.
+func (mf *Makefile) lookup(name string) (*MakeTarget, error) {
+	if mf.target != nil && mf.target.namespace != "" {
+		if tgt := mf.findExact(mf.target.namespace + ":" + name); tgt != nil {
+			return tgt, nil
+		}
+	}
+	if tgt := mf.findExact(name); tgt != nil {
+		return tgt, nil
+	}
+	suffix := ":" + strings.ToLower(name)
+	var found []*MakeTarget
+	for _, tgt := range mf.targets {
+		if strings.HasSuffix(strings.ToLower(tgt.Name), suffix) {
+			found = append(found, tgt)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("unknown target: %s%s", name, mf.suggest(name))
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, 0, len(found))
+	for _, tgt := range found {
+		names = append(names, tgt.Name)
+	}
+	return nil, fmt.Errorf("ambiguous target: %s could be %s", name, strings.Join(names, ", "))
+}
+
+// findExact returns the target with the given fully-qualified name,
+// or the target created from the pattern target that matches it.
+func (mf *Makefile) findExact(name string) *MakeTarget {
+	for _, tgt := range mf.targets {
+		if strings.EqualFold(tgt.Name, name) {
+			return tgt
+		}
+	}
+	return mf.findPattern(name)
+}
+
+// Mount adds the targets and pattern targets of another Makefile under the given namespace,
+// so that the `build` target of other becomes the `prefix:build` target of mf.
+// Their dependencies are renamed in the same way, and the names the mounted targets pass to Find, Depend,
+// and DependParallel are resolved in the namespace first, so they keep referring to each other.
+// The default target of other is not the default target of mf, and its global flags and hooks are not mounted.
+func (mf *Makefile) Mount(prefix string, other *Makefile) {
+	qualify := func(deps []string, resolve func(string) *MakeTarget) []string {
+		if deps == nil {
+			return nil
+		}
+		qualified := make([]string, 0, len(deps))
+		for _, dep := range deps {
+			if tgt := resolve(dep); tgt != nil {
+				dep = tgt.Name
+			}
+			qualified = append(qualified, prefix+":"+dep)
+		}
+		return qualified
+	}
+	namespace := func(ns string) string {
+		if ns == "" {
+			return prefix
+		}
+		return prefix + ":" + ns
+	}
+	for _, tgt := range other.targets {
+		mounted := *tgt
+		mounted.Name = prefix + ":" + tgt.Name
+		mounted.Default = false
+		mounted.Deps = qualify(tgt.Deps, (&Makefile{makefileState: other.makefileState, target: tgt}).Find)
+		mounted.namespace = namespace(tgt.namespace)
+		mf.targets = append(mf.targets, &mounted)
+	}
+	for _, pt := range other.patterns {
+		mounted := *pt
+		mounted.Name = prefix + ":" + pt.Name
+		mounted.Deps = qualify(pt.Deps, func(dep string) *MakeTarget {
+			if strings.Contains(dep, "%") {
+				return nil
+			}
+			return other.Find(dep)
+		})
+		mounted.namespace = namespace(pt.namespace)
+		mf.patterns = append(mf.patterns, &mounted)
+	}
+	mf.patternsMu.Lock()
+	mf.instances = nil
+	mf.patternsMu.Unlock()
+	mf.normalize()
+}
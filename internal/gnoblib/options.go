@@ -0,0 +1,203 @@
+package gnoblib
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// makeOptions are the global flags of the Makefile, given on the command line before the targets.
+type makeOptions struct {
+	help       bool
+	verbose    bool
+	quiet      bool
+	dir        string
+	jobs       int
+	parallel   bool
+	dryRun     bool
+	keepGoing  bool
+	summary    string
+	trace      string
+	list       formatValue
+	graph      formatValue
+	completion string
+	watch      bool
+	genDocs    string
+	vars       string
+}
+
+// formatValue is a flag that can be given with or without an output format, like `-graph` or `-graph=json`.
+// It is empty when the flag is not given.
+type formatValue string
+
+func (v *formatValue) String() string {
+	return string(*v)
+}
+
+func (v *formatValue) Set(s string) error {
+	if b, err := strconv.ParseBool(s); err == nil {
+		if !b {
+			*v = ""
+			return nil
+		}
+		s = "text"
+	}
+	*v = formatValue(s)
+	return nil
+}
+
+func (v *formatValue) IsBoolFlag() bool {
+	return true
+}
+
+// optionSet returns the flag set of the global flags of the Makefile.
+func (mf *Makefile) optionSet() (*flag.FlagSet, *makeOptions) {
+	opts := &makeOptions{jobs: mf.Jobs()}
+	fs := flag.NewFlagSet(mf.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&opts.help, "help", false, "show the targets, or the help of the given target")
+	fs.BoolVar(&opts.help, "h", false, "shorthand for -help")
+	fs.BoolVar(&opts.verbose, "v", false, "show debug messages")
+	fs.BoolVar(&opts.quiet, "q", false, "only show warnings and errors")
+	fs.StringVar(&opts.dir, "C", "", "change to `dir` before running the targets")
+	fs.IntVar(&opts.jobs, "j", opts.jobs, "maximum number of targets executed at the same time")
+	fs.BoolVar(&opts.parallel, "parallel", false, "execute the targets given on the command line concurrently")
+	fs.BoolVar(&opts.dryRun, "n", false, "print the targets that would run, without executing them")
+	fs.BoolVar(&opts.keepGoing, "k", false, "keep going after a target fails, skipping only the targets that depend on it")
+	fs.StringVar(&opts.summary, "summary-json", "", "write the summary of the run as JSON to `file`")
+	fs.StringVar(&opts.trace, "trace", "", "write a Chrome trace of the targets and commands to `file`, to be loaded in Perfetto")
+	fs.Var(&opts.list, "list", "list the targets, one per line, or as JSON with -list=json")
+	fs.Var(&opts.graph, "graph", "show the dependency graph as DOT with -graph=dot, or as JSON with -graph=json, after executing the given targets")
+	fs.BoolVar(&opts.watch, "watch", false, "execute the targets again each time the files they are built from change")
+	fs.StringVar(&opts.vars, "vars", "", "read the variables from `file`, with KEY=value lines or a JSON object, instead of gnob.env or gnob.json")
+	fs.StringVar(&opts.genDocs, "gen-docs", "", "print the documentation of the targets as markdown, man, or with the template `file`")
+	fs.StringVar(&opts.completion, "completion", "", "print the completion script for `shell`, which is bash, zsh, or fish")
+	return fs, opts
+}
+
+// parseOptions parses the global flags from the beginning of the argument list, and returns the remaining arguments.
+// Both `-flag` and `--flag` are accepted.
+func (mf *Makefile) parseOptions(args []string) (*makeOptions, []string, error) {
+	fs, opts := mf.optionSet()
+	if err := fs.Parse(expandJobs(fs, args)); err != nil {
+		return nil, nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "j" {
+			mf.SetJobs(opts.jobs)
+		}
+	})
+	mf.parallel = opts.parallel
+	mf.dryRun = opts.dryRun
+	mf.keepGoing = opts.keepGoing
+	mf.summaryFile = opts.summary
+	if opts.trace != "" {
+		traces.enable(opts.trace)
+	}
+	switch {
+	case opts.verbose:
+		SetLogLevel(slog.LevelDebug)
+	case opts.quiet:
+		SetLogLevel(slog.LevelWarn)
+	}
+	if opts.graph == "text" {
+		opts.graph = "dot"
+	}
+	return opts, fs.Args(), nil
+}
+
+// expandJobs rewrites the "-jN" shorthand of make to "-j=N" in the global flags of the argument list.
+// The values of the flags of fs that take one, like `-C dir`, are skipped.
+func expandJobs(fs *flag.FlagSet, args []string) []string {
+	args = slices.Clone(args)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "--" {
+			break
+		}
+		if n := strings.TrimPrefix(arg, "-j"); n != arg && n != "" && n[0] != '=' {
+			args[i] = "-j=" + n
+		} else if takesValue(fs, arg) {
+			i++
+		}
+	}
+	return args
+}
+
+// takesValue returns true if the word is a flag of fs that takes its value from the next word,
+// like `-C dir`, but not `-C=dir` or a boolean flag.
+func takesValue(fs *flag.FlagSet, word string) bool {
+	name, _, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+	f := fs.Lookup(name)
+	if f == nil || hasValue {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// makeListEntry is a target listed by `gnob -list=json`.
+type makeListEntry struct {
+	Name    string   `json:"name"`
+	Desc    string   `json:"desc,omitempty"`
+	Default bool     `json:"default,omitempty"`
+	Pattern bool     `json:"pattern,omitempty"`
+	Deps    []string `json:"deps,omitempty"`
+	Flags   []string `json:"flags,omitempty"`
+}
+
+// showList prints the targets that are not hidden in the given format.
+// The text format has one target per line, followed by a tab and its description.
+func (mf *Makefile) showList(format formatValue) error {
+	flagNames := func(flags []TargetFlag) []string {
+		var names []string
+		for _, f := range flags {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+	var entries []makeListEntry
+	for i, tgt := range mf.targets {
+		if tgt.Hidden {
+			continue
+		}
+		entries = append(entries, makeListEntry{
+			Name:    tgt.Name,
+			Desc:    tgt.Desc,
+			Default: i == mf.defaultTarget,
+			Deps:    tgt.Deps,
+			Flags:   flagNames(tgt.Flags),
+		})
+	}
+	for _, pt := range mf.patterns {
+		if pt.Hidden {
+			continue
+		}
+		entries = append(entries, makeListEntry{
+			Name:    pt.Name,
+			Desc:    pt.Desc,
+			Pattern: true,
+			Deps:    pt.Deps,
+			Flags:   flagNames(pt.Flags),
+		})
+	}
+	switch format {
+	case "text":
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\n", e.Name, e.Desc)
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	default:
+		return fmt.Errorf("unknown list format: %s", format)
+	}
+}
@@ -0,0 +1,155 @@
+package gnoblib
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PatternTarget is a rule for targets whose names match a pattern, like the `%.o: %.c` rules of Make.
+// The Name is a pattern containing a single '%', which matches any non-empty stem.
+// When Makefile.Find is called with a name that no other target has, and that matches the pattern,
+// a MakeTarget is created on demand from the PatternTarget, with every '%' in its Deps, Inputs, and Outputs
+// replaced by the stem.
+// If several patterns match a name, the one with the shortest stem is used.
+type PatternTarget struct {
+	// Name is the pattern of the names of the targets, like `examples/%/gnob`.
+	Name string
+	// Desc is a short description of the targets.
+	// It is shown when listing all targets with `gnob -help`.
+	Desc string
+	// LongDesc is a long description of the targets.
+	// It is shown when running `gnob -help <target>`.
+	LongDesc string
+	// Hidden is true if the pattern should be hidden from listing.
+	Hidden bool
+	// Flags are the flags the targets accept on the command line.
+	Flags []TargetFlag
+	// Deps are the names of the targets the targets depend on. They may contain '%'.
+	Deps []string
+	// Inputs are the files the targets are built from. They may contain '%' and be glob patterns.
+	Inputs []string
+	// Outputs are the files the targets produce. They may contain '%' and be glob patterns.
+	Outputs []string
+	// UpToDate is a function that returns true if the target with the given stem is up-to-date.
+	UpToDate func(mf *Makefile, stem string) bool
+	// Body is the function that executes the target with the given stem.
+	Body func(ctx context.Context, mf *Makefile, stem string) error
+	// Timeout is the maximum time each execution of the Body may take.
+	Timeout time.Duration
+	// Retries is the number of times the Body is executed again after it fails or times out.
+	Retries int
+	// RetryBackoff is the delay before the first retry, which doubles after each retry.
+	RetryBackoff time.Duration
+	// EchoCommands is true if the Body only has side effects through the commands it executes with Cmd.Exec.
+	EchoCommands bool
+	// namespace is the prefix under which the pattern target was mounted with Makefile.Mount, if any.
+	namespace string
+}
+
+// AddPattern adds pattern targets to the Makefile.
+func (mf *Makefile) AddPattern(pts ...PatternTarget) {
+	for _, pt := range pts {
+		if strings.Count(pt.Name, "%") != 1 {
+			Logger.Warn("[gnob] pattern target must contain a single '%'", "name", pt.Name)
+			continue
+		}
+		mf.patterns = append(mf.patterns, &pt)
+	}
+	mf.patternsMu.Lock()
+	mf.instances = nil
+	mf.patternsMu.Unlock()
+	mf.normalize()
+}
+
+// match returns the stem if the name matches the pattern of the target.
+func (pt *PatternTarget) match(name string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(pt.Name, "%")
+	if len(name) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+	if !strings.EqualFold(name[:len(prefix)], prefix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// instance creates the MakeTarget with the given name and stem from the pattern target.
+func (pt *PatternTarget) instance(name string, stem string) *MakeTarget {
+	expand := func(patterns []string) []string {
+		if patterns == nil {
+			return nil
+		}
+		expanded := make([]string, 0, len(patterns))
+		for _, p := range patterns {
+			expanded = append(expanded, strings.ReplaceAll(p, "%", stem))
+		}
+		return expanded
+	}
+	tgt := &MakeTarget{
+		Name:         name,
+		Desc:         pt.Desc,
+		LongDesc:     pt.LongDesc,
+		Hidden:       pt.Hidden,
+		Flags:        pt.Flags,
+		Deps:         expand(pt.Deps),
+		Inputs:       expand(pt.Inputs),
+		Outputs:      expand(pt.Outputs),
+		Timeout:      pt.Timeout,
+		Retries:      pt.Retries,
+		RetryBackoff: pt.RetryBackoff,
+		EchoCommands: pt.EchoCommands,
+		namespace:    pt.namespace,
+		Body: func(ctx context.Context, mf *Makefile) error {
+			return pt.Body(ctx, mf, stem)
+		},
+	}
+	if pt.UpToDate != nil {
+		tgt.UpToDate = func(mf *Makefile) bool {
+			return pt.UpToDate(mf, stem)
+		}
+	}
+	return tgt
+}
+
+// findPattern returns the target created from the pattern target that matches the name with the shortest stem.
+// Targets are created once per name, so that they are executed at most once per run.
+// If no pattern target matches, it returns nil.
+func (mf *Makefile) findPattern(name string) *MakeTarget {
+	var (
+		found *PatternTarget
+		stem  string
+	)
+	for _, pt := range mf.patterns {
+		if s, ok := pt.match(name); ok && (found == nil || len(s) < len(stem)) {
+			found, stem = pt, s
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	key := strings.ToLower(name)
+	mf.patternsMu.Lock()
+	defer mf.patternsMu.Unlock()
+	if tgt, ok := mf.instances[key]; ok {
+		return tgt
+	}
+	if mf.instances == nil {
+		mf.instances = make(map[string]*MakeTarget)
+	}
+	tgt := found.instance(name, stem)
+	mf.instances[key] = tgt
+	Logger.Debug("[gnob:makefile] pattern target matched", "target", name, "pattern", found.Name, "stem", stem)
+	return tgt
+}
+
+// patternInstances returns the targets created from pattern targets so far, sorted by name.
+func (mf *Makefile) patternInstances() []*MakeTarget {
+	mf.patternsMu.Lock()
+	defer mf.patternsMu.Unlock()
+	return slices.SortedFunc(maps.Values(mf.instances), func(a, b *MakeTarget) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
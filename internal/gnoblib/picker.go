@@ -0,0 +1,113 @@
+package gnoblib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isTerminal returns true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// visibleTargets returns the targets that are not hidden.
+func (mf *Makefile) visibleTargets() []*MakeTarget {
+	var targets []*MakeTarget
+	for _, tgt := range mf.targets {
+		if !tgt.Hidden {
+			targets = append(targets, tgt)
+		}
+	}
+	return targets
+}
+
+// pickTarget returns the target to execute when none is given on the command line, and no target is the default.
+// On a terminal, it shows a menu of the targets that are not hidden, from which the user picks one by number,
+// or by typing part of its name or description to filter the menu.
+// Otherwise, it returns an error listing the targets, instead of guessing.
+func (mf *Makefile) pickTarget() (*MakeTarget, error) {
+	targets := mf.visibleTargets()
+	if len(targets) == 0 {
+		return nil, errors.New("no target to execute")
+	}
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		var sb strings.Builder
+		sb.WriteString("no target given, and no default target; run one of:")
+		writeTargetMenu(&sb, targets, false)
+		return nil, errors.New(sb.String())
+	}
+	return pickTargetFrom(os.Stdin, os.Stdout, targets)
+}
+
+// pickTargetFrom shows the menu of targets on w, and reads the choice of the user from r.
+func pickTargetFrom(r io.Reader, w io.Writer, targets []*MakeTarget) (*MakeTarget, error) {
+	in := bufio.NewReader(r)
+	matches := targets
+	for {
+		writeTargetMenu(w, matches, true)
+		_, _ = fmt.Fprint(w, "\nSelect a target by number, or type to filter: ")
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			_, _ = fmt.Fprintln(w)
+			return nil, errors.New("no target selected")
+		}
+		line = strings.TrimSpace(line)
+		if n, err := strconv.Atoi(line); err == nil {
+			if n >= 1 && n <= len(matches) {
+				return matches[n-1], nil
+			}
+			_, _ = fmt.Fprintf(w, "no target numbered %d\n", n)
+			continue
+		}
+		if line == "" {
+			if len(matches) == 1 {
+				return matches[0], nil
+			}
+			matches = targets
+			continue
+		}
+		filtered := filterTargets(targets, line)
+		switch len(filtered) {
+		case 0:
+			_, _ = fmt.Fprintf(w, "no target matches %q\n", line)
+			matches = targets
+		case 1:
+			return filtered[0], nil
+		default:
+			matches = filtered
+		}
+	}
+}
+
+// filterTargets returns the targets whose name or description contains the filter, ignoring case.
+func filterTargets(targets []*MakeTarget, filter string) []*MakeTarget {
+	filter = strings.ToLower(filter)
+	var matches []*MakeTarget
+	for _, tgt := range targets {
+		if strings.Contains(strings.ToLower(tgt.Name), filter) || strings.Contains(strings.ToLower(tgt.Desc), filter) {
+			matches = append(matches, tgt)
+		}
+	}
+	return matches
+}
+
+// writeTargetMenu writes the targets with their description, one per line, numbered if numbered is true.
+func writeTargetMenu(w io.Writer, targets []*MakeTarget, numbered bool) {
+	maxLen := 0
+	for _, tgt := range targets {
+		maxLen = max(maxLen, len(tgt.Name))
+	}
+	for i, tgt := range targets {
+		if numbered {
+			_, _ = fmt.Fprintf(w, "\n%3d) %-*s   %s", i+1, maxLen, tgt.Name, tgt.Desc)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "\n  %-*s   %s", maxLen, tgt.Name, tgt.Desc)
+	}
+}
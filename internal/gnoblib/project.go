@@ -0,0 +1,145 @@
+package gnoblib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Project is another gnob project in a directory of its own, like the `build/` directory of a component in a monorepo.
+// Its targets can be executed with Run, or imported into a Makefile with Makefile.Import.
+type Project struct {
+	// Dir is the directory of the project.
+	Dir string
+	// Sources are the source files of the gnob binary of the project, relative to Dir.
+	Sources []string
+
+	mu sync.Mutex
+}
+
+// Project returns the gnob project in the given directory.
+// The gnob binary of the project is built from the given sources, which default to `*.go`.
+func (_makefile) Project(dir string, sources ...string) *Project {
+	if len(sources) == 0 {
+		sources = []string{"*.go"}
+	}
+	return &Project{Dir: dir, Sources: sources}
+}
+
+// Build builds the gnob binary of the project if any of its sources is newer than the binary,
+// like RebuildYourself does for the running binary.
+func (p *Project) Build(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var f _files
+	binary := filepath.Join(p.Dir, binaryName())
+	sources := make([]string, 0, len(p.Sources))
+	for _, s := range p.Sources {
+		sources = append(sources, filepath.Join(p.Dir, s))
+	}
+	if !f.TargetNeedsUpdate(binary, sources...) {
+		Logger.DebugContext(ctx, "[gnob:project] gnob is up to date", "dir", p.Dir)
+		return nil
+	}
+	Logger.DebugContext(ctx, "[gnob:project] building gnob", "dir", p.Dir)
+	var c _cmd
+	var stderr bytes.Buffer
+	err := c.ExecOpt(ctx, c.ExecOptions(c.WithDir(p.Dir), c.WithStdout(os.Stdout), c.WithStderr(&stderr)),
+		GoCommand, "build", "-tags", buildTags(), "-o", binaryName(), ".").Run()
+	if err != nil {
+		return fmt.Errorf("failed to build gnob in %s: %w\n%s", p.Dir, err, stderr.String())
+	}
+	return nil
+}
+
+// Exec creates a command that executes the gnob binary of the project with the given arguments, in its directory.
+// The standard output and error of the command are those of the current process.
+// It does not build the binary, see Run.
+func (p *Project) Exec(ctx context.Context, args ...string) *Exec {
+	return p.ExecOpt(ctx, nil, args...)
+}
+
+// ExecOpt is like Exec, but you can specify options to customize the command.
+func (p *Project) ExecOpt(ctx context.Context, opt ExecOption, args ...string) *Exec {
+	var c _cmd
+	opts := c.ExecOptions(c.WithDir(p.Dir), c.WithStdout(os.Stdout), c.WithStderr(os.Stderr))
+	if opt != nil {
+		opts = c.ExecOptions(opts, opt)
+	}
+	return c.ExecOpt(ctx, opts, "."+string(filepath.Separator)+binaryName(), args...)
+}
+
+// Run builds the gnob binary of the project if needed, and executes it with the given arguments,
+// like `gnob test` in the directory of the project.
+func (p *Project) Run(ctx context.Context, args ...string) error {
+	if err := p.Build(ctx); err != nil {
+		return err
+	}
+	return p.Exec(ctx, args...).Run()
+}
+
+// targets returns the targets of the project, as listed by `gnob -list=json`.
+func (p *Project) targets(ctx context.Context) ([]makeListEntry, error) {
+	if err := p.Build(ctx); err != nil {
+		return nil, err
+	}
+	var (
+		c      _cmd
+		stdout bytes.Buffer
+	)
+	if err := p.ExecOpt(ctx, c.WithStdout(&stdout), "-list=json").Run(); err != nil {
+		return nil, fmt.Errorf("unable to list the targets of %s: %w", p.Dir, err)
+	}
+	var entries []makeListEntry
+	if err := json.Unmarshal(stdout.Bytes(), &entries); err != nil {
+		return nil, fmt.Errorf("unable to decode the targets of %s: %w", p.Dir, err)
+	}
+	return entries, nil
+}
+
+// Import adds the targets of the project under the given namespace, so that `gnob -help` lists them,
+// and running the `prefix:test` target runs `gnob test` in the directory of the project.
+// The arguments of the imported targets are passed to the gnob binary of the project; use `--` to pass flags,
+// like `gnob prefix:build -- -race`.
+// The gnob binary of the project is built if needed to list its targets.
+func (mf *Makefile) Import(ctx context.Context, prefix string, p *Project) error {
+	entries, err := p.targets(ctx)
+	if err != nil {
+		return err
+	}
+	var (
+		targets  []MakeTarget
+		patterns []PatternTarget
+	)
+	for _, e := range entries {
+		if e.Pattern {
+			patterns = append(patterns, PatternTarget{
+				Name:         prefix + ":" + e.Name,
+				Desc:         e.Desc,
+				EchoCommands: true,
+				Body: func(ctx context.Context, mf *Makefile, stem string) error {
+					return p.Run(ctx, append([]string{strings.Replace(e.Name, "%", stem, 1)}, mf.TargetArgs()...)...)
+				},
+			})
+			continue
+		}
+		targets = append(targets, MakeTarget{
+			Name:         prefix + ":" + e.Name,
+			Desc:         e.Desc,
+			EchoCommands: true,
+			Body: func(ctx context.Context, mf *Makefile) error {
+				return p.Run(ctx, append([]string{e.Name}, mf.TargetArgs()...)...)
+			},
+		})
+	}
+	mf.Add(targets...)
+	if len(patterns) > 0 {
+		mf.AddPattern(patterns...)
+	}
+	return nil
+}
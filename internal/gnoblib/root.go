@@ -27,12 +27,7 @@ var (
 
 func (r _root) GoRebuildYourself(sources ...string) {
 	if BinaryName == "" {
-		switch runtime.GOOS {
-		case "windows":
-			BinaryName = "gnob.exe"
-		default:
-			BinaryName = "gnob"
-		}
+		BinaryName = binaryName()
 	}
 	if err := r.RebuildYourself(context.Background(), sources...); err != nil {
 		Logger.Error("[gnob:rebuild] failed to rebuild", "error", err)
@@ -47,7 +42,36 @@ func (r _root) GoRebuildYourself(sources ...string) {
 type _root struct {
 }
 
+// binaryName returns the BinaryName, which defaults to gnob, or gnob.exe on Windows.
+func binaryName() string {
+	if BinaryName != "" {
+		return BinaryName
+	}
+	if runtime.GOOS == "windows" {
+		return "gnob.exe"
+	}
+	return "gnob"
+}
+
+// buildTags returns the build tags the running binary was built with, which default to gnob.
+func buildTags() string {
+	if rbi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range rbi.Settings {
+			if s.Key == "-tags" {
+				return s.Value
+			}
+		}
+	}
+	return "gnob"
+}
+
+// RebuildYourself rebuilds the gnob binary from the sources if any of them is newer than the binary,
+// and executes the new binary with the same arguments.
+// If a trace file is given with `-trace <file>`, the rebuild is added to the trace written by the new binary.
 func (r _root) RebuildYourself(ctx context.Context, sources ...string) error {
+	if file := traceFlag(os.Args[1:]); file != "" {
+		traces.enable(file)
+	}
 	if os.Getenv(EnvRebuildDisable) != "" {
 		Logger.DebugContext(ctx, "[gnob:rebuild] rebuild disabled")
 		return nil
@@ -72,6 +96,7 @@ func (r _root) RebuildYourself(ctx context.Context, sources ...string) error {
 		if err = r.runBinary(ctx, BinaryName); err != nil {
 			return fmt.Errorf("failed to run %s: %v", BinaryName, err)
 		}
+		r.writeTrace()
 		os.Exit(0)
 	}
 	sources, err = r.normalizeSources(binary, sources)
@@ -88,6 +113,7 @@ func (r _root) RebuildYourself(ctx context.Context, sources ...string) error {
 		if err = r.runBinary(ctx, binary); err != nil {
 			return fmt.Errorf("failed to run %s: %v", binary, err)
 		}
+		r.writeTrace()
 		os.Exit(0)
 	}
 	Logger.DebugContext(ctx, "[gnob:rebuild] gnob is up to date")
@@ -134,21 +160,21 @@ func (r _root) runBinary(ctx context.Context, binary string) error {
 	return cmd.Run()
 }
 
-func (r _root) rebuild(ctx context.Context, binary string, sources []string) error {
+// writeTrace adds the spans of this process to the trace written by the binary it executed.
+func (r _root) writeTrace() {
+	if err := traces.write(true); err != nil {
+		Logger.Warn("[gnob:rebuild] unable to write trace", "error", err)
+	}
+}
+
+func (r _root) rebuild(ctx context.Context, binary string, sources []string) (err error) {
+	span := traces.start("gnob", "rebuild", map[string]any{"binary": binary})
+	defer func() { span.end(err) }()
 	Logger.DebugContext(ctx, "[gnob:rebuild] rebuilding", "binary", binary)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	tags := "gnob"
-	if rbi, ok := debug.ReadBuildInfo(); ok {
-		for _, s := range rbi.Settings {
-			if s.Key == "-tags" {
-				tags = s.Value
-				break
-			}
-		}
-	}
 	args := []string{"build",
-		"-tags", tags,
+		"-tags", buildTags(),
 		"-o", binary,
 	}
 	var stderr bytes.Buffer
@@ -157,7 +183,7 @@ func (r _root) rebuild(ctx context.Context, binary string, sources []string) err
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 	cmd.Stdin = os.Stdin
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return err
 	}
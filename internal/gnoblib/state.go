@@ -0,0 +1,114 @@
+package gnoblib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateDir is the directory where gnob stores the state it keeps between runs.
+// It is relative to the working directory, and should be ignored by version control.
+var StateDir = ".gnob"
+
+const (
+	hashStateFile   = "hashes.json"
+	targetStateFile = "state"
+)
+
+// Results of a target recorded in a TargetState.
+// TargetSkipped and TargetUpToDate are only reported in the summary of a run, since the body is not executed.
+const (
+	TargetSucceeded = "success"
+	TargetFailed    = "failure"
+	TargetTimedOut  = "timeout"
+	TargetSkipped   = "skipped"
+	TargetUpToDate  = "up-to-date"
+)
+
+// TargetState is the state of a target recorded in the state database when its body was last executed.
+type TargetState struct {
+	// LastRun is the time the body of the target was last executed.
+	LastRun time.Time `json:"lastRun"`
+	// Duration is how long the body of the target took to execute.
+	Duration time.Duration `json:"duration"`
+	// Inputs are the SHA-256 digests of the inputs of the target, by path.
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// Outputs are the outputs declared by the target.
+	Outputs []string `json:"outputs,omitempty"`
+	// Result is either TargetSucceeded, TargetFailed, or TargetTimedOut.
+	Result string `json:"result"`
+	// Error is the error message if the target failed.
+	Error string `json:"error,omitempty"`
+}
+
+// stateMu serializes updates to the files in the StateDir.
+var stateMu sync.Mutex
+
+// hashRecord is the state recorded by HashUpToDate after a target is built.
+type hashRecord struct {
+	// Target is the digest of the target file.
+	Target string `json:"target"`
+	// Sources are the digests of the source files, by path.
+	Sources map[string]string `json:"sources"`
+}
+
+// loadState decodes the JSON state file with the given name from the StateDir into v.
+// If the file does not exist, v is left unchanged.
+func loadState(name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(StateDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read state file %q: %w", name, err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to decode state file %q: %w", name, err)
+	}
+	return nil
+}
+
+// saveState encodes v as JSON into the state file with the given name in the StateDir.
+// The file is replaced atomically, so concurrent readers never see a partial file.
+func saveState(name string, v any) error {
+	if err := os.MkdirAll(StateDir, 0o755); err != nil {
+		return fmt.Errorf("unable to create state directory %q: %w", StateDir, err)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode state file %q: %w", name, err)
+	}
+	tmp, err := os.CreateTemp(StateDir, name+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create state file %q: %w", name, err)
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write state file %q: %w", name, err)
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to close state file %q: %w", name, err)
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(StateDir, name)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to replace state file %q: %w", name, err)
+	}
+	return nil
+}
+
+// updateState loads the state file with the given name, passes it to fn to be modified, and saves it again.
+func updateState[T any](name string, fn func(state map[string]T)) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	state := make(map[string]T)
+	if err := loadState(name, &state); err != nil {
+		return err
+	}
+	fn(state)
+	return saveState(name, state)
+}
@@ -0,0 +1,170 @@
+package gnoblib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RunSummary is the summary of the targets executed during a run of the Makefile.
+type RunSummary struct {
+	// Targets are the executed targets, in the order they finished.
+	Targets []TargetSummary `json:"targets"`
+	// CriticalPath is the chain of dependencies that determined how long the run took,
+	// from the first dependency on the chain to the target that finished last.
+	CriticalPath []string `json:"criticalPath,omitempty"`
+	// Duration is the time between the start of the first target and the end of the last target.
+	Duration time.Duration `json:"duration"`
+}
+
+// TargetSummary is the summary of a target executed during a run of the Makefile.
+type TargetSummary struct {
+	// Name is the name of the target.
+	Name string `json:"name"`
+	// Result is one of TargetSucceeded, TargetFailed, TargetTimedOut, TargetSkipped, or TargetUpToDate.
+	Result string `json:"result"`
+	// Attempts is the number of times the Body was executed, which is more than one if it was retried.
+	Attempts int `json:"attempts,omitempty"`
+	// Start is when the target started, after its declared dependencies finished.
+	// It is zero for skipped targets.
+	Start time.Time `json:"start,omitzero"`
+	// Duration is the wall time of the target, including the dependencies it takes with Depend.
+	Duration time.Duration `json:"duration"`
+	// Error is the error message if the target failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Summary returns the summary of the targets executed during the last run of the Makefile.
+func (mf *Makefile) Summary() *RunSummary {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	s := &RunSummary{Targets: make([]TargetSummary, 0, len(mf.finished))}
+	var first, last time.Time
+	var lastTarget *MakeTarget
+	for _, tgt := range mf.finished {
+		run := mf.runs[tgt]
+		ts := TargetSummary{Name: tgt.Name, Result: run.result, Attempts: run.attempts, Start: run.start}
+		if !run.start.IsZero() {
+			ts.Duration = run.end.Sub(run.start)
+			if first.IsZero() || run.start.Before(first) {
+				first = run.start
+			}
+		}
+		if (run.result == TargetFailed || run.result == TargetTimedOut) && run.err != nil {
+			ts.Error = run.err.Error()
+		}
+		if lastTarget == nil || run.end.After(last) {
+			last, lastTarget = run.end, tgt
+		}
+		s.Targets = append(s.Targets, ts)
+	}
+	if !first.IsZero() {
+		s.Duration = last.Sub(first)
+	}
+	s.CriticalPath = mf.criticalPath(lastTarget)
+	return s
+}
+
+// criticalPath follows the dependencies of the target that finished last,
+// each time picking the dependency that finished last.
+// The caller must hold runsMu.
+func (mf *Makefile) criticalPath(tgt *MakeTarget) []string {
+	var path []string
+	for tgt != nil {
+		path = append(path, tgt.Name)
+		var next *MakeTarget
+		for e := range mf.edges {
+			if e.from != tgt {
+				continue
+			}
+			run, ok := mf.runs[e.to]
+			if !ok || run.end.IsZero() {
+				continue
+			}
+			if next == nil || run.end.After(mf.runs[next].end) {
+				next = e.to
+			}
+		}
+		tgt = next
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// WriteJSON writes the summary as JSON to w.
+func (s *RunSummary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteTable writes the summary as a table to w, followed by the critical path.
+func (s *RunSummary) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tRESULT\tTIME")
+	for _, t := range s.Targets {
+		result := t.Result
+		if t.Attempts > 1 {
+			result += fmt.Sprintf(" (%d attempts)", t.Attempts)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, result, t.Duration.Round(time.Millisecond))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(s.CriticalPath) > 0 {
+		_, err := fmt.Fprintf(w, "Critical path: %s (%s)\n", strings.Join(s.CriticalPath, " -> "), s.Duration.Round(time.Millisecond))
+		return err
+	}
+	return nil
+}
+
+// showSummary prints the summary of the run to stderr, and writes it as JSON to the file given with -summary-json.
+// Nothing is printed if no target was executed, or in dry-run mode.
+func (mf *Makefile) showSummary() {
+	mf.runsMu.Lock()
+	n := len(mf.finished)
+	mf.runsMu.Unlock()
+	if n == 0 || mf.dryRun {
+		return
+	}
+	s := mf.Summary()
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err := s.WriteTable(os.Stderr); err != nil {
+		Logger.Warn("[gnob:makefile] unable to print summary", "error", err)
+	}
+	if mf.summaryFile == "" {
+		return
+	}
+	f, err := os.Create(mf.summaryFile)
+	if err == nil {
+		err = errors.Join(s.WriteJSON(f), f.Close())
+	}
+	if err != nil {
+		Logger.Warn("[gnob:makefile] unable to write summary", "file", mf.summaryFile, "error", err)
+	}
+}
+
+// keepGoingResult returns the errors of the targets that failed in keep-going mode.
+// Targets that were skipped because a dependency failed do not add to the error.
+// If no target failed, err is returned.
+func (mf *Makefile) keepGoingResult(err error) error {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	var errs []error
+	for _, tgt := range mf.finished {
+		if run := mf.runs[tgt]; run.result == TargetFailed || run.result == TargetTimedOut {
+			errs = append(errs, fmt.Errorf("target %s: %w", tgt.Name, run.err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return err
+}
@@ -1,23 +1,108 @@
 package gnoblib
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 type _makefile struct {
 }
 
+// ErrTargetTimeout is the error of a target whose Body did not finish within its Timeout.
+var ErrTargetTimeout = errors.New("target timed out")
+
+// StateUpToDate returns a function that returns true if the target is up-to-date.
+// The target is up-to-date if its last execution recorded in the state database succeeded,
+// the SHA-256 digests of the inputs have not changed since, and every output exists.
+// Inputs and outputs may be glob patterns.
+// This is useful for targets that do not produce a single file, like running tests.
+// The result of the execution is recorded in the state database when the body of the target finishes.
+// This can be used as the UpToDate function of a MakeTarget.
+func (_makefile) StateUpToDate(inputs []string, outputs []string) func(*Makefile) bool {
+	var f _files
+	return func(mf *Makefile) bool {
+		mf.watchFiles(inputs...)
+		digests, err := f.Digests(inputs...)
+		if err != nil {
+			Logger.Warn("[gnob:makefile] unable to compute digests", "error", err)
+			return false
+		}
+		if mf.run != nil {
+			mf.run.state = &TargetState{Inputs: digests, Outputs: outputs}
+		}
+		if mf.target == nil {
+			return false
+		}
+		state, ok := mf.State(mf.target.Name)
+		if !ok || state.Result != TargetSucceeded || !maps.Equal(state.Inputs, digests) {
+			return false
+		}
+		for _, output := range outputs {
+			if matches, _ := filepath.Glob(output); len(matches) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// HashUpToDate returns a function that returns true if the target is up-to-date.
+// The target is up-to-date if neither the target nor the sources have changed since the target was last built,
+// according to the SHA-256 digests of their contents.
+// Unlike FileUpToDate, it does not rely on modification times, so it is not affected by
+// checking out files, restoring caches, or copying files.
+// The digests are recorded in the StateDir after the body of the target succeeds.
+// This can be used as the UpToDate function of a MakeTarget.
+func (_makefile) HashUpToDate(target string, sources ...string) func(*Makefile) bool {
+	var f _files
+	return func(mf *Makefile) bool {
+		mf.watchFiles(sources...)
+		digests, err := f.Digests(sources...)
+		if err != nil {
+			Logger.Warn("[gnob:makefile] unable to compute digests", "target", target, "error", err)
+			return false
+		}
+		var state map[string]hashRecord
+		if err = loadState(hashStateFile, &state); err != nil {
+			Logger.Warn("[gnob:makefile] unable to load hash state", "error", err)
+		}
+		rec, ok := state[target]
+		if ok && f.Exists(target) && maps.Equal(rec.Sources, digests) {
+			if digest, err := f.Digest(target); err == nil && digest == rec.Target {
+				return true
+			}
+		}
+		mf.afterSuccess(func() error {
+			digest, err := f.Digest(target)
+			if err != nil {
+				return fmt.Errorf("unable to record digest of %q: %w", target, err)
+			}
+			return updateState(hashStateFile, func(state map[string]hashRecord) {
+				state[target] = hashRecord{Target: digest, Sources: digests}
+			})
+		})
+		return false
+	}
+}
+
 // FileUpToDate returns a function that returns true if the target is up-to-date.
 // The target is up-to-date if the target file is newer than all the sources.
 // This can be used as the UpToDate function of a MakeTarget.
 func (_makefile) FileUpToDate(target string, sources ...string) func(*Makefile) bool {
 	var f _files
 	return func(mf *Makefile) bool {
+		mf.watchFiles(sources...)
 		return !f.TargetNeedsUpdate(target, sources...)
 	}
 }
@@ -25,14 +110,78 @@ func (_makefile) FileUpToDate(target string, sources ...string) func(*Makefile)
 // Makefile is a collection of targets.
 // This can be used as a main function to make gnob behave like a Makefile.
 type Makefile struct {
-	name          string
-	args          []string
-	commandArgs   []string
-	targets       []*MakeTarget
+	*makefileState
+	// target is the target that is being executed with this Makefile, and run is its result.
+	// Both are nil for the Makefile returned by New and NewEx.
+	target *MakeTarget
+	run    *targetRun
+}
+
+// makefileState is shared between a Makefile and the copies of it that are passed to each target.
+type makefileState struct {
+	name        string
+	args        []string
+	commandArgs []string
+	targets     []*MakeTarget
+	patterns    []*PatternTarget
+	patternsMu  sync.Mutex
+	instances   map[string]*MakeTarget
+	// defaultTarget is the index of the target with Default set, or -1 if there is none.
 	defaultTarget int
-	ctx           context.Context
+	jobs          int
+	// jobSlots limits the number of targets executed at the same time by all the calls to DependParallel.
+	jobSlots    chan struct{}
+	parallel    bool
+	dryRun      bool
+	keepGoing   bool
+	hooks       makeHooks
+	summaryFile string
+	// finished are the targets that finished executing during the run, in order.
+	finished     []*MakeTarget
+	runsMu       sync.Mutex
+	runs         map[*MakeTarget]*targetRun
+	commandLines map[*MakeTarget]commandLine
+	edges        map[makeEdge]struct{}
+	depsErr      error
+	// vars are the declared variables, sorted by name, and cmdVars and fileVars are the values of the variables
+	// given on the command line and in the variables file.
+	vars     []MakeVar
+	cmdVars  map[string]string
+	fileVars map[string]string
+	// duplicates are the names of the targets that were discarded because another target has the same name.
+	duplicates []string
+	ctx        context.Context
+}
+
+// targetRun is the result of executing a target once during a run of the Makefile.
+// done is closed when the target has finished executing.
+type targetRun struct {
+	done chan struct{}
+	err  error
+	// result is one of TargetSucceeded, TargetFailed, TargetSkipped, or TargetUpToDate once the target has finished.
+	result string
+	// start is when the dependencies of the target finished, and end is when the target finished.
+	start, end time.Time
+	// attempts is the number of times the Body was executed.
+	attempts  int
+	onSuccess []func() error
+	// cmd is the flags and arguments of the target, parsed from the command line if the target was given on it.
+	cmd commandLine
+	// state is recorded in the state database when the body of the target finishes.
+	// It is nil unless the UpToDate function of the target uses the state database.
+	state *TargetState
+	// watched are the files given to the up-to-date functions of the target, which are watched in watch mode.
+	watched []string
+	// waiting counts the running targets that the target, or a dependency it is executing, waits for.
+	// It is guarded by runsMu, and used to detect dependency cycles across parallel dependencies.
+	waiting map[*MakeTarget]int
 }
 
+type targetStackKey struct{}
+
+// jobSlotKey is the key of the job slots in the context of a target that holds one of them.
+type jobSlotKey struct{}
+
 // New construct a makefile from the given targets.
 // The name of the program is taken from the first argument of os.Args.
 // The argument list is taken from the second argument of os.Args.
@@ -46,50 +195,192 @@ func (_makefile) NewEx(name string, args []string, targets ...MakeTarget) *Makef
 	for i := range targets {
 		tgt = append(tgt, &targets[i])
 	}
-	td := Makefile{
-		name:    name,
-		args:    args,
-		targets: tgt,
+	td := &Makefile{
+		makefileState: &makefileState{
+			name:    name,
+			args:    args,
+			targets: tgt,
+			jobs:    runtime.NumCPU(),
+		},
 	}
 	td.normalize()
-	return &td
+	td.logHooks()
+	return td
 }
 
 // Depend executes the targets with the given names.
 // Execution is done in the order of the names.
 // If any of the targets is not found, it returns an error.
 // If any of the targets encounters an error, it returns the error immediately.
+// In keep-going mode, the remaining targets are still executed, and the errors are joined together.
 // If all targets are executed successfully, it returns nil.
 func (mf *Makefile) Depend(ctx context.Context, names ...string) error {
-	if len(names) == 0 {
-		return nil
+	targets, err := mf.findAll(names)
+	if err != nil {
+		return err
 	}
-	targets := make([]*MakeTarget, 0, len(names))
-	for _, name := range names {
-		found := mf.Find(name)
-		if found != nil {
-			targets = append(targets, found)
-			continue
+	return mf.execAll(ctx, targets)
+}
+
+// execAll executes the targets in order.
+// It stops at the first error, unless the Makefile is in keep-going mode.
+func (mf *Makefile) execAll(ctx context.Context, targets []*MakeTarget) error {
+	var errs []error
+	for _, tgt := range targets {
+		if err := tgt.exec(ctx, mf); err != nil {
+			if !mf.keepGoing {
+				return err
+			}
+			errs = append(errs, err)
 		}
-		return fmt.Errorf("unknown target: %s", name)
 	}
+	return errors.Join(errs...)
+}
+
+// DependParallel is like Depend, but executes the targets concurrently.
+// At most Jobs targets are executed at the same time, counting the targets of all the calls to DependParallel.
+// In dry-run mode, the targets are executed in order, like Depend.
+// If any of the targets is not found, it returns an error before executing anything.
+// If any of the targets encounters an error, the context of the other targets is cancelled
+// and no more targets are started, unless the Makefile is in keep-going mode.
+// The errors of all the failed targets are joined together.
+func (mf *Makefile) DependParallel(ctx context.Context, names ...string) error {
+	targets, err := mf.findAll(names)
+	if err != nil {
+		return err
+	}
+	if mf.dryRun {
+		return mf.Depend(ctx, names...)
+	}
+	return mf.execParallel(ctx, targets)
+}
+
+// DryRun returns true if the Makefile is running in dry-run mode, with `gnob -n`.
+// In dry-run mode, the targets that would run are printed in order instead of executed.
+func (mf *Makefile) DryRun() bool {
+	return mf.dryRun
+}
+
+// execParallel executes the targets concurrently, at most Jobs at the same time.
+// The job slot of the calling target, if any, is released while it waits for the targets.
+func (mf *Makefile) execParallel(ctx context.Context, targets []*MakeTarget) error {
+	defer mf.releaseJob(ctx)()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := mf.jobSemaphore()
+	jobCtx := context.WithValue(ctx, jobSlotKey{}, sem)
 	for _, tgt := range targets {
-		if err := tgt.exec(ctx, mf); err != nil {
-			return err
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := tgt.exec(jobCtx, mf); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				if !mf.keepGoing {
+					cancel()
+				}
+			}
+		}()
 	}
-	return nil
+	wg.Wait()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return ctx.Err()
 }
 
-// Find returns the target with the given name.
-// If the target is not found, it returns nil.
-func (mf *Makefile) Find(name string) *MakeTarget {
-	for _, tgt := range mf.targets {
-		if strings.EqualFold(tgt.Name, name) {
-			return tgt
+// jobSemaphore returns the job slots of the Makefile, creating them on first use.
+func (mf *Makefile) jobSemaphore() chan struct{} {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	if mf.jobSlots == nil {
+		mf.jobSlots = make(chan struct{}, mf.jobs)
+	}
+	return mf.jobSlots
+}
+
+// releaseJob releases the job slot held by the target of the context while it waits for other targets,
+// so that they can use it. The returned function takes a job slot again once the wait is over.
+// The slot is returned to the job slots it was taken from, even if SetJobs replaced them since.
+func (mf *Makefile) releaseJob(ctx context.Context) func() {
+	sem, _ := ctx.Value(jobSlotKey{}).(chan struct{})
+	if sem == nil {
+		return func() {}
+	}
+	<-sem
+	return func() { sem <- struct{}{} }
+}
+
+// State returns the state recorded in the state database for the target with the given name.
+// It returns false if the target has never been executed with a StateUpToDate function.
+func (mf *Makefile) State(name string) (TargetState, bool) {
+	if tgt := mf.Find(name); tgt != nil {
+		name = tgt.Name
+	}
+	var states map[string]TargetState
+	if err := loadState(targetStateFile, &states); err != nil {
+		Logger.Warn("[gnob:makefile] unable to load target state", "error", err)
+		return TargetState{}, false
+	}
+	state, ok := states[name]
+	return state, ok
+}
+
+// Jobs returns the maximum number of targets DependParallel executes at the same time, across all its calls.
+func (mf *Makefile) Jobs() int {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	return mf.jobs
+}
+
+// SetJobs sets the maximum number of targets DependParallel executes at the same time.
+// If n is less than 1, the number of CPUs is used.
+// This can also be set on the command line with `gnob -j N <target>`.
+// When called while targets are executing, the new limit applies to the targets started afterwards.
+func (mf *Makefile) SetJobs(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	mf.runsMu.Lock()
+	mf.jobs = n
+	mf.jobSlots = nil
+	mf.runsMu.Unlock()
+}
+
+func (mf *Makefile) findAll(names []string) ([]*MakeTarget, error) {
+	targets := make([]*MakeTarget, 0, len(names))
+	for _, name := range names {
+		found, err := mf.lookup(name)
+		if err != nil {
+			return nil, err
 		}
+		targets = append(targets, found)
 	}
-	return nil
+	return targets, nil
+}
+
+// Find returns the target with the given name.
+// If no target has the name, the target is created from the pattern target that matches it.
+// Otherwise, the name may be a short name, like `build` for `docker:build`, if only one target has it.
+// When called from the Body of a mounted target, the name is first resolved in the namespace of the target.
+// If the target is not found, or the short name is ambiguous, it returns nil.
+func (mf *Makefile) Find(name string) *MakeTarget {
+	tgt, _ := mf.lookup(name)
+	return tgt
 }
 
 // Add adds more targets to the Makefile.
@@ -101,46 +392,134 @@ func (mf *Makefile) Add(tgts ...MakeTarget) {
 }
 
 // TargetArgs returns the argument list for the target.
+// The flags declared by the target are parsed from the argument list, and only the remaining arguments are returned.
+// Targets that were not given on the command line, like dependencies, have no arguments.
 func (mf *Makefile) TargetArgs() []string {
+	if mf.run != nil {
+		return mf.run.cmd.args
+	}
 	return mf.commandArgs
 }
 
 // Run runs the target.
+// When it finishes, it prints the summary of the executed targets.
 // If it encounters an error, it logs the error and exits with status code 1.
 func (mf *Makefile) Run(ctx context.Context) {
 	mf.ctx = ctx
-	if err := mf.RunE(ctx); err != nil {
+	err := mf.RunE(ctx)
+	mf.showSummary()
+	if err != nil {
 		Logger.Error("[gnob:makefile] error running build target", "error", err)
 		os.Exit(1)
 	}
 }
 
-// RunE runs the target and returns an error if any.
+// RunE runs the targets given on the command line and returns an error if any.
+// Several targets can be given, each followed by its own flags and arguments, like `gnob build -race test example`.
+// They are executed in order, or concurrently when the -parallel flag is given.
+// Each target is executed at most once per call to RunE,
+// no matter how many other targets depend on it.
 func (mf *Makefile) RunE(ctx context.Context) error {
-	if len(mf.args) == 0 {
-		return mf.runDefault(ctx)
+	if err := mf.Validate(); err != nil {
+		return err
+	}
+	mf.resetRuns()
+	mf.runsMu.Lock()
+	mf.commandLines = nil
+	mf.runsMu.Unlock()
+	mf.parallel = false
+	mf.dryRun = false
+	mf.keepGoing = false
+	mf.summaryFile = ""
+	mf.cmdVars = nil
+	if len(mf.args) > 0 && mf.args[0] == completeCommand {
+		return mf.showCompletions(mf.args[1:])
+	}
+	opts, args, err := mf.parseOptions(mf.args)
+	if err != nil {
+		return err
+	}
+	mf.commandArgs = args
+	if opts.trace != "" {
+		defer func() {
+			if err := traces.write(false); err != nil {
+				Logger.Warn("[gnob:makefile] unable to write trace", "error", err)
+			}
+			traces.reset()
+		}()
+	}
+	if opts.dir != "" {
+		if err = os.Chdir(opts.dir); err != nil {
+			return fmt.Errorf("unable to change directory: %w", err)
+		}
 	}
-	cmd := mf.args[0]
-	mf.commandArgs = mf.args[1:]
-	if cmd == "-help" {
+	if err = mf.loadVars(opts.vars); err != nil {
+		return err
+	}
+	switch {
+	case opts.help:
 		return mf.showHelp()
+	case opts.list != "":
+		return mf.showList(opts.list)
+	case opts.graph != "":
+		return mf.showGraph(ctx, string(opts.graph))
+	case opts.completion != "":
+		return mf.showCompletion(opts.completion)
+	case opts.genDocs != "":
+		return mf.WriteDocs(os.Stdout, opts.genDocs)
+	}
+	targets, err := mf.parseCommandLine(args)
+	if err == nil && len(targets) == 0 {
+		if mf.defaultTarget >= 0 {
+			targets = []*MakeTarget{mf.targets[mf.defaultTarget]}
+		} else {
+			var tgt *MakeTarget
+			if tgt, err = mf.pickTarget(); err == nil {
+				targets = []*MakeTarget{tgt}
+			}
+		}
 	}
-	tgt := mf.Find(cmd)
-	if tgt == nil {
-		return fmt.Errorf("unknown target: %s", cmd)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return targets[0].showHelp(mf)
+		}
+		return err
+	}
+	if opts.watch {
+		return mf.watch(ctx, targets)
 	}
-	return tgt.exec(ctx, mf)
+	return mf.runTargets(ctx, targets)
 }
 
-func (mf *Makefile) runDefault(ctx context.Context) error {
-	return mf.targets[mf.defaultTarget].exec(ctx, mf)
+// runTargets executes the targets given on the command line, calling the BeforeRun and AfterRun hooks.
+func (mf *Makefile) runTargets(ctx context.Context, targets []*MakeTarget) error {
+	for _, fn := range mf.hooks.beforeRun {
+		fn(ctx, targets)
+	}
+	var err error
+	if mf.parallel && !mf.dryRun {
+		err = mf.execParallel(ctx, targets)
+	} else {
+		err = mf.execAll(ctx, targets)
+	}
+	if mf.keepGoing {
+		err = mf.keepGoingResult(err)
+	}
+	for _, fn := range mf.hooks.afterRun {
+		fn(ctx, err)
+	}
+	return err
 }
 
 func (mf *Makefile) showHelp() error {
-	if len(mf.commandArgs) > 0 {
-		tgt := mf.Find(mf.commandArgs[0])
-		if tgt == nil {
-			return fmt.Errorf("unknown target: %s", mf.commandArgs[0])
+	args := mf.commandArgs
+	for len(args) > 0 && mf.parseVar(args[0]) {
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		tgt, err := mf.lookup(args[0])
+		if err != nil {
+			return err
 		}
 		return tgt.showHelp(mf)
 	}
@@ -151,18 +530,41 @@ func (mf *Makefile) showHelp() error {
 		}
 		maxLen = max(maxLen, len(tgt.Name))
 	}
-	fmt.Println("Usage: " + filepath.Base(mf.name) + " [-help] [target]")
-	fmt.Println("Targets:")
-	fmtStr := fmt.Sprintf("%%-%ds   %%s\n", maxLen)
-	for i, tgt := range mf.targets {
-		if tgt.Hidden {
+	for _, pt := range mf.patterns {
+		if pt.Hidden {
 			continue
 		}
-		if mf.defaultTarget == i {
-			fmt.Printf("* "+fmtStr, tgt.Name, tgt.Desc)
-			continue
+		maxLen = max(maxLen, len(pt.Name))
+	}
+	fmt.Println("Usage: " + filepath.Base(mf.name) + " [flags] [target [flags] [args]...]")
+	fmt.Println("Flags:")
+	fs, _ := mf.optionSet()
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+	mf.showVars()
+	fmtStr := fmt.Sprintf("%%-%ds   %%s\n", maxLen)
+	for _, ns := range mf.namespaces() {
+		if ns == "" {
+			fmt.Println("Targets:")
+		} else {
+			fmt.Printf("\n%s:\n", ns)
+		}
+		for i, tgt := range mf.targets {
+			if tgt.Hidden || namespaceOf(tgt.Name) != ns {
+				continue
+			}
+			if mf.defaultTarget == i {
+				fmt.Printf("* "+fmtStr, tgt.Name, tgt.Desc)
+				continue
+			}
+			fmt.Printf("  "+fmtStr, tgt.Name, tgt.Desc)
+		}
+		for _, pt := range mf.patterns {
+			if pt.Hidden || namespaceOf(pt.Name) != ns {
+				continue
+			}
+			fmt.Printf("  "+fmtStr, pt.Name, pt.Desc)
 		}
-		fmt.Printf("  "+fmtStr, tgt.Name, tgt.Desc)
 	}
 	fmt.Println("\n* (default target)")
 	return nil
@@ -174,30 +576,90 @@ func (mf *Makefile) normalize() {
 	for i := range mf.targets {
 		name := strings.ToLower(mf.targets[i].Name)
 		if _, ok := names[name]; ok {
-			Logger.Warn("[gnob] duplicate target", "name", name, "index", i)
-			// skip duplicate targets
+			Logger.Debug("[gnob] duplicate target", "name", name, "index", i)
+			// skip duplicate targets, which are reported by Validate
+			mf.duplicates = append(mf.duplicates, mf.targets[i].Name)
 			continue
 		}
 		normalTargets = append(normalTargets, mf.targets[i])
 		names[name] = struct{}{}
 	}
 	slices.SortFunc(normalTargets, func(a, b *MakeTarget) int {
-		if a.Default {
-			return -1
-		}
-		if b.Default {
+		if a.Default != b.Default {
+			if a.Default {
+				return -1
+			}
 			return 1
 		}
 		return strings.Compare(a.Name, b.Name)
 	})
 	mf.targets = normalTargets
-	mf.defaultTarget = 0
+	mf.defaultTarget = -1
 	for i := range mf.targets {
 		if mf.targets[i].Default {
 			mf.defaultTarget = i
 			break
 		}
 	}
+	mf.depsErr = mf.resolveDeps()
+}
+
+// resolveDeps validates the Deps of all targets.
+// It returns an error if a dependency refers to an unknown target,
+// or if the dependencies do not form a directed acyclic graph.
+func (mf *Makefile) resolveDeps() error {
+	var errs []error
+	for _, tgt := range mf.targets {
+		for _, dep := range tgt.Deps {
+			if mf.Find(dep) == nil {
+				errs = append(errs, fmt.Errorf("target %s: unknown dependency: %s%s", tgt.Name, dep, mf.suggest(dep)))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*MakeTarget]int, len(mf.targets))
+	var path []*MakeTarget
+	var visit func(tgt *MakeTarget) error
+	visit = func(tgt *MakeTarget) error {
+		switch state[tgt] {
+		case visited:
+			return nil
+		case visiting:
+			return cycleError(append(path[slices.Index(path, tgt):], tgt))
+		}
+		state[tgt] = visiting
+		path = append(path, tgt)
+		for _, dep := range tgt.Deps {
+			if err := visit(mf.Find(dep)); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[tgt] = visited
+		return nil
+	}
+	for _, tgt := range mf.targets {
+		if err := visit(tgt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cycleError returns an error describing the dependency cycle.
+// The path must start and end with the same target.
+func cycleError(path []*MakeTarget) error {
+	names := make([]string, 0, len(path))
+	for _, tgt := range path {
+		names = append(names, tgt.Name)
+	}
+	return fmt.Errorf("dependency cycle: %s", strings.Join(names, " -> "))
 }
 
 // MakeTarget is a target that can be executed by a Makefile.
@@ -215,26 +677,338 @@ type MakeTarget struct {
 	// Default is true if the target is the default target.
 	// Only one target can be the default target.
 	Default bool
+	// Flags are the flags the target accepts on the command line, like `gnob build -race`.
+	// Their values can be read from the Body with Makefile.Bool, Makefile.String, Makefile.Int, and Makefile.Duration.
+	// When the target is executed as a dependency, the flags have their default values.
+	Flags []TargetFlag
+	// Deps are the names of the targets this target depends on.
+	// They are executed in order, before checking UpToDate and executing the Body.
+	// Every dependency must name a known target, and the dependencies must not form a cycle.
+	Deps []string
+	// Inputs are the files the target is built from. They may be glob patterns.
+	Inputs []string
+	// Outputs are the files the target produces. They may be glob patterns.
+	// If the target has Outputs and no UpToDate function, it is up-to-date when every output exists
+	// and is newer than all the Inputs.
+	// After the Body succeeds, every output must exist, otherwise the target fails.
+	Outputs []string
 	// UpToDate is a function that returns true if the target is up-to-date.
 	// If the target is up-to-date, the target will not be executed.
+	// It takes precedence over the up-to-date check derived from Inputs and Outputs.
 	UpToDate func(mf *Makefile) bool
 	// Body is the function that executes the target.
 	// When the target is not up-to-date, this body will be executed.
 	Body func(ctx context.Context, mf *Makefile) error
+	// Timeout is the maximum time each execution of the Body may take.
+	// The context passed to the Body is cancelled when it expires, and the target fails with ErrTargetTimeout.
+	// If it is zero, there is no timeout.
+	Timeout time.Duration
+	// Retries is the number of times the Body is executed again after it fails or times out.
+	Retries int
+	// RetryBackoff is the delay before the first retry, which doubles after each retry.
+	// If it is zero, the delay starts at one second.
+	RetryBackoff time.Duration
+	// EchoCommands is true if the Body only has side effects through the commands it executes with Cmd.Exec.
+	// In dry-run mode, the Body of such a target is called, and its commands are printed instead of executed.
+	// The Body of other targets is never called in dry-run mode.
+	EchoCommands bool
+	// namespace is the prefix under which the target was mounted with Makefile.Mount, if any.
+	// Names given to Find by the Body of the target are resolved in this namespace first.
+	namespace string
 }
 
-// Exec executes the target.
+// exec executes the target at most once per run of the Makefile.
+// If the target is already executing, it waits for it to finish and returns the same result.
 func (mt *MakeTarget) exec(ctx context.Context, mf *Makefile) error {
-	Logger.Debug("[gnob:makefile] execute target", "target", mt.Name)
-	if mt.UpToDate == nil || !mt.UpToDate(mf) {
-		if err := mt.Body(ctx, mf); err != nil {
-			Logger.Error("[gnob:makefile] error executing target", "target", mt.Name, "error", err)
-			return err
+	stack, _ := ctx.Value(targetStackKey{}).([]*MakeTarget)
+	if i := slices.Index(stack, mt); i >= 0 {
+		return cycleError(append(slices.Clip(stack[i:]), mt))
+	}
+	mf.runsMu.Lock()
+	if len(stack) > 0 {
+		if mf.edges == nil {
+			mf.edges = make(map[makeEdge]struct{})
+		}
+		mf.edges[makeEdge{from: stack[len(stack)-1], to: mt}] = struct{}{}
+	}
+	if mf.runs == nil {
+		mf.runs = make(map[*MakeTarget]*targetRun)
+	}
+	run, ok := mf.runs[mt]
+	if !ok {
+		run = &targetRun{done: make(chan struct{}), cmd: mf.commandLines[mt]}
+		if run.cmd.flags == nil {
+			run.cmd.flags = mt.flagSet()
+		}
+		mf.runs[mt] = run
+	}
+	if ok {
+		select {
+		case <-run.done:
+		default:
+			if path := mf.waitCycle(stack, mt); path != nil {
+				mf.runsMu.Unlock()
+				return cycleError(path)
+			}
+			mf.addWait(stack, mt, 1)
+			defer func() {
+				mf.runsMu.Lock()
+				mf.addWait(stack, mt, -1)
+				mf.runsMu.Unlock()
+			}()
+		}
+	}
+	mf.runsMu.Unlock()
+	if ok {
+		Logger.Debug("[gnob:makefile] target already executed", "target", mt.Name)
+		defer mf.releaseJob(ctx)()
+		select {
+		case <-run.done:
+			return run.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ctx = context.WithValue(ctx, targetStackKey{}, append(slices.Clip(stack), mt))
+	span := traces.start("target", mt.Name, nil)
+	run.err = mt.run(ctx, &Makefile{makefileState: mf.makefileState, target: mt, run: run})
+	if span != nil {
+		span.args = map[string]any{"result": run.result}
+	}
+	span.end(run.err)
+	mf.runsMu.Lock()
+	run.end = time.Now()
+	mf.finished = append(mf.finished, mt)
+	mf.runsMu.Unlock()
+	close(run.done)
+	return run.err
+}
+
+// waitCycle returns the dependency cycle that waiting for the running target mt would create, or nil if there is none.
+// Waiting for mt creates a cycle if mt, or a target it waits for, waits for one of the targets on the stack.
+// It must be called with runsMu held.
+func (mf *Makefile) waitCycle(stack []*MakeTarget, mt *MakeTarget) []*MakeTarget {
+	visited := make(map[*MakeTarget]bool)
+	// walk returns the targets from tgt to the target on the stack it waits for
+	var walk func(tgt *MakeTarget) []*MakeTarget
+	walk = func(tgt *MakeTarget) []*MakeTarget {
+		if slices.Contains(stack, tgt) {
+			return []*MakeTarget{tgt}
+		}
+		if visited[tgt] || mf.runs[tgt] == nil {
+			return nil
+		}
+		visited[tgt] = true
+		for next := range mf.runs[tgt].waiting {
+			if path := walk(next); path != nil {
+				return append([]*MakeTarget{tgt}, path...)
+			}
 		}
 		return nil
 	}
-	Logger.Info("[gnob:makefile] target is up-to-date", "target", mt.Name)
-	return nil
+	path := walk(mt)
+	if path == nil {
+		return nil
+	}
+	i := slices.Index(stack, path[len(path)-1])
+	return append(slices.Clone(stack[i:]), path...)
+}
+
+// addWait adds delta to the number of times the targets on the stack wait for the running target mt.
+// It must be called with runsMu held.
+func (mf *Makefile) addWait(stack []*MakeTarget, mt *MakeTarget, delta int) {
+	for _, tgt := range stack {
+		run := mf.runs[tgt]
+		if run == nil {
+			continue
+		}
+		if run.waiting == nil {
+			run.waiting = make(map[*MakeTarget]int)
+		}
+		if run.waiting[mt] += delta; run.waiting[mt] <= 0 {
+			delete(run.waiting, mt)
+		}
+	}
+}
+
+// afterSuccess registers a function that is called after the body of the executing target succeeds.
+// It is used by UpToDate functions to record the state of a target once it has been built.
+// It returns false if the Makefile is not executing a target.
+func (mf *Makefile) afterSuccess(fn func() error) bool {
+	if mf.run == nil {
+		return false
+	}
+	mf.run.onSuccess = append(mf.run.onSuccess, fn)
+	return true
+}
+
+// run executes the dependencies of the target, and then builds it.
+// The lifecycle hooks of the Makefile are called once the dependencies of the target finished.
+func (mt *MakeTarget) run(ctx context.Context, mf *Makefile) error {
+	if err := mf.Depend(ctx, mt.Deps...); err != nil {
+		mf.run.result = TargetSkipped
+		return err
+	}
+	mf.run.start = time.Now()
+	emit(ctx, mf.hooks.targetStart, TargetEvent{Target: mt})
+	err := mt.build(ctx, mf)
+	ev := TargetEvent{Target: mt, Result: mf.run.result, Duration: time.Since(mf.run.start), Err: err}
+	switch {
+	case mf.run.result == TargetUpToDate:
+		emit(ctx, mf.hooks.upToDate, ev)
+	case err != nil:
+		emit(ctx, mf.hooks.onError, ev)
+	}
+	emit(ctx, mf.hooks.targetFinish, ev)
+	return err
+}
+
+// build executes the body of the target unless it is up-to-date, and records its result.
+// In dry-run mode, a target is not up-to-date if any of its dependencies would run, like with `make -n`,
+// since they would change the files it is built from.
+func (mt *MakeTarget) build(ctx context.Context, mf *Makefile) error {
+	if !(mf.dryRun && mf.depsWouldRun(mt)) && mt.upToDate(mf) {
+		mf.run.result = TargetUpToDate
+		if mf.dryRun {
+			fmt.Printf("%s (up-to-date)\n", mt.Name)
+		}
+		return nil
+	}
+	if mf.dryRun {
+		return mt.dryRun(ctx, mf)
+	}
+	start := time.Now()
+	err := mt.attempt(ctx, mf)
+	backoff := cmp.Or(mt.RetryBackoff, time.Second)
+retries:
+	for retry := 0; err != nil && retry < mt.Retries && ctx.Err() == nil; retry++ {
+		Logger.Warn("[gnob:makefile] retrying target", "target", mt.Name, "attempt", retry+2, "delay", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			err = errors.Join(err, ctx.Err())
+			break retries
+		}
+		backoff *= 2
+		err = mt.attempt(ctx, mf)
+	}
+	if err == nil {
+		var errs []error
+		for _, fn := range mf.run.onSuccess {
+			errs = append(errs, fn())
+		}
+		err = errors.Join(errs...)
+	}
+	switch {
+	case err == nil:
+		mf.run.result = TargetSucceeded
+	case errors.Is(err, ErrTargetTimeout):
+		mf.run.result = TargetTimedOut
+	default:
+		mf.run.result = TargetFailed
+	}
+	if state := mf.run.state; state != nil {
+		state.LastRun = start
+		state.Duration = time.Since(start)
+		state.Result = mf.run.result
+		if err != nil {
+			state.Error = err.Error()
+		}
+		if serr := updateState(targetStateFile, func(states map[string]TargetState) {
+			states[mt.Name] = *state
+		}); serr != nil {
+			Logger.Warn("[gnob:makefile] unable to record target state", "target", mt.Name, "error", serr)
+		}
+	}
+	return err
+}
+
+// attempt executes the Body of the target once, within its Timeout, and checks its Outputs.
+func (mt *MakeTarget) attempt(ctx context.Context, mf *Makefile) error {
+	mf.run.attempts++
+	if mt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, mt.Timeout, ErrTargetTimeout)
+		defer cancel()
+	}
+	err := mt.Body(ctx, mf)
+	if mt.Timeout > 0 && errors.Is(context.Cause(ctx), ErrTargetTimeout) {
+		if err == nil {
+			return fmt.Errorf("%w after %s", ErrTargetTimeout, mt.Timeout)
+		}
+		return fmt.Errorf("%w after %s: %w", ErrTargetTimeout, mt.Timeout, err)
+	}
+	if err != nil {
+		return err
+	}
+	return mt.checkOutputs()
+}
+
+// depsWouldRun returns true if any of the targets that mt depended on so far would run in dry-run mode,
+// because they are not up-to-date.
+func (mf *Makefile) depsWouldRun(mt *MakeTarget) bool {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	for e := range mf.edges {
+		if e.from != mt {
+			continue
+		}
+		if run := mf.runs[e.to]; run != nil && run.result != TargetUpToDate {
+			return true
+		}
+	}
+	return false
+}
+
+// dryRun prints that the target would run, and the commands it would execute if it has EchoCommands.
+func (mt *MakeTarget) dryRun(ctx context.Context, mf *Makefile) error {
+	fmt.Println(mt.Name)
+	if !mt.EchoCommands {
+		return nil
+	}
+	var cmd _cmd
+	return mt.Body(cmd.DryRun(ctx, os.Stdout), mf)
+}
+
+// upToDate returns true if the target is up-to-date.
+// It uses the UpToDate function if there is one, and otherwise compares the modification times
+// of the Inputs and Outputs.
+func (mt *MakeTarget) upToDate(mf *Makefile) bool {
+	if mt.UpToDate != nil {
+		return mt.UpToDate(mf)
+	}
+	if len(mt.Outputs) == 0 {
+		return false
+	}
+	var f _files
+	var oldest time.Time
+	for _, output := range mt.Outputs {
+		matches, _ := filepath.Glob(output)
+		if len(matches) == 0 {
+			return false
+		}
+		for _, p := range matches {
+			ts := f.modTime(p)
+			if ts.IsZero() {
+				return false
+			}
+			if oldest.IsZero() || ts.Before(oldest) {
+				oldest = ts
+			}
+		}
+	}
+	return !f.LatestTimestamp(mt.Inputs...).After(oldest)
+}
+
+// checkOutputs returns an error if any of the Outputs of the target does not exist.
+func (mt *MakeTarget) checkOutputs() error {
+	var errs []error
+	for _, output := range mt.Outputs {
+		if matches, _ := filepath.Glob(output); len(matches) == 0 {
+			errs = append(errs, fmt.Errorf("target %s: missing output: %s", mt.Name, output))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (mt *MakeTarget) showHelp(mf *Makefile) error {
@@ -245,5 +1019,18 @@ func (mt *MakeTarget) showHelp(mf *Makefile) error {
 	if mt.LongDesc != "" {
 		fmt.Println(mt.LongDesc)
 	}
+	if len(mt.Deps) > 0 {
+		fmt.Println()
+		fmt.Println("Dependencies: " + strings.Join(mt.Deps, ", "))
+	}
+	mt.showFlags()
+	if len(mt.Inputs) > 0 {
+		fmt.Println()
+		fmt.Println("Inputs: " + strings.Join(mt.Inputs, ", "))
+	}
+	if len(mt.Outputs) > 0 {
+		fmt.Println()
+		fmt.Println("Outputs: " + strings.Join(mt.Outputs, ", "))
+	}
 	return nil
 }
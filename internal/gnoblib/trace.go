@@ -0,0 +1,175 @@
+package gnoblib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceEvent is an event in the Chrome trace-event format, which can be loaded in Perfetto or chrome://tracing.
+// Timestamps and durations are in microseconds.
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   int64          `json:"ts"`
+	Dur  int64          `json:"dur,omitempty"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// traceFile is the JSON object format of a Chrome trace file.
+type traceFile struct {
+	TraceEvents []traceEvent `json:"traceEvents"`
+}
+
+// tracer records the spans of targets and commands while tracing is enabled with `gnob -trace <file>`.
+// Spans that overlap in time, like targets running concurrently or the commands of a pipeline,
+// are placed on different lanes, so that they are shown correctly.
+type tracer struct {
+	mu     sync.Mutex
+	file   string
+	events []traceEvent
+	lanes  []bool
+}
+
+var traces tracer
+
+// traceSpan is a span started by the tracer.
+// A nil traceSpan is valid, and is returned when tracing is disabled.
+type traceSpan struct {
+	t     *tracer
+	name  string
+	cat   string
+	lane  int
+	start time.Time
+	args  map[string]any
+}
+
+// enable starts recording spans, to be written to the given file.
+// A relative file is resolved against the current working directory.
+func (t *tracer) enable(file string) {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file = file
+}
+
+// reset stops recording spans, and discards the recorded spans.
+func (t *tracer) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file = ""
+	t.events = nil
+}
+
+// start starts a span with the given category and name on the first free lane.
+// It returns nil if tracing is disabled.
+func (t *tracer) start(cat string, name string, args map[string]any) *traceSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == "" {
+		return nil
+	}
+	lane := slices.Index(t.lanes, false)
+	if lane < 0 {
+		lane = len(t.lanes)
+		t.lanes = append(t.lanes, true)
+	}
+	t.lanes[lane] = true
+	return &traceSpan{t: t, name: name, cat: cat, lane: lane, start: time.Now(), args: args}
+}
+
+// end ends the span, recording the error if any, and frees its lane.
+func (s *traceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	if err != nil {
+		if s.args == nil {
+			s.args = make(map[string]any)
+		}
+		s.args["error"] = err.Error()
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.lanes[s.lane] = false
+	s.t.events = append(s.t.events, traceEvent{
+		Name: s.name,
+		Cat:  s.cat,
+		Ph:   "X",
+		Ts:   s.start.UnixMicro(),
+		Dur:  max(end.Sub(s.start).Microseconds(), 1),
+		Pid:  os.Getpid(),
+		Tid:  s.lane + 1,
+		Args: s.args,
+	})
+}
+
+// write writes the recorded spans to the trace file.
+// If merge is true, the spans are added to the events already in the file,
+// which is used to add the spans of a parent process to the trace of the gnob binary it executed.
+func (t *tracer) write(merge bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == "" {
+		return nil
+	}
+	var tf traceFile
+	if merge {
+		data, err := os.ReadFile(t.file)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to read trace file %q: %w", t.file, err)
+		}
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &tf); err != nil {
+				return fmt.Errorf("unable to decode trace file %q: %w", t.file, err)
+			}
+		}
+	}
+	tf.TraceEvents = append(tf.TraceEvents, traceEvent{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  os.Getpid(),
+		Args: map[string]any{"name": filepath.Base(os.Args[0])},
+	})
+	tf.TraceEvents = append(tf.TraceEvents, t.events...)
+	data, err := json.Marshal(tf)
+	if err != nil {
+		return fmt.Errorf("unable to encode trace file %q: %w", t.file, err)
+	}
+	if err = os.WriteFile(t.file, data, 0o644); err != nil {
+		return fmt.Errorf("unable to write trace file %q: %w", t.file, err)
+	}
+	return nil
+}
+
+// traceFlag returns the value of the -trace flag in the argument list, if any.
+// It is used before the Makefile parses its flags, so that rebuilding gnob can be traced too.
+func traceFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "trace" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package gnoblib
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Validate checks the targets of the Makefile, and returns the problems it finds joined together:
+// several targets with the same name, several targets with Default set, targets without a Body,
+// and dependencies on unknown targets or that form a cycle.
+// It is called by RunE before executing any target.
+func (mf *Makefile) Validate() error {
+	var errs []error
+	for _, name := range mf.duplicates {
+		errs = append(errs, fmt.Errorf("duplicate target: %s", name))
+	}
+	var defaults []string
+	for _, tgt := range mf.targets {
+		if tgt.Default {
+			defaults = append(defaults, tgt.Name)
+		}
+		if tgt.Body == nil {
+			errs = append(errs, fmt.Errorf("target %s: no Body", tgt.Name))
+		}
+	}
+	if len(defaults) > 1 {
+		errs = append(errs, fmt.Errorf("multiple default targets: %s", strings.Join(defaults, ", ")))
+	}
+	for _, pt := range mf.patterns {
+		if pt.Body == nil {
+			errs = append(errs, fmt.Errorf("pattern target %s: no Body", pt.Name))
+		}
+	}
+	if mf.depsErr != nil {
+		errs = append(errs, mf.depsErr)
+	}
+	return errors.Join(errs...)
+}
+
+// suggest returns a suggestion of the names of the targets that are not hidden
+// and are closest to the given unknown name, like ` (did you mean test?)`.
+// It returns an empty string if no name is close enough.
+func (mf *Makefile) suggest(name string) string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	name = strings.ToLower(name)
+	limit := max(1, (len(name)+2)/3)
+	var candidates []candidate
+	for _, tgt := range mf.targets {
+		if tgt.Hidden {
+			continue
+		}
+		d := editDistance(name, strings.ToLower(tgt.Name))
+		if short := tgt.Name[strings.LastIndexByte(tgt.Name, ':')+1:]; short != tgt.Name {
+			d = min(d, editDistance(name, strings.ToLower(short)))
+		}
+		if d <= limit {
+			candidates = append(candidates, candidate{name: tgt.Name, distance: d})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.distance, b.distance), strings.Compare(a.name, b.name))
+	})
+	names := make([]string, 0, 3)
+	for _, c := range candidates[:min(len(candidates), 3)] {
+		names = append(names, c.name)
+	}
+	return " (did you mean " + strings.Join(names, " or ") + "?)"
+}
+
+// editDistance returns the number of insertions, deletions, substitutions,
+// and transpositions of adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// rows i-2, i-1, and i of the distance matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
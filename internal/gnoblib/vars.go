@@ -0,0 +1,246 @@
+package gnoblib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VarsFiles are the files the variables of a Makefile are read from when no file is given with `gnob -vars <file>`.
+// The first file that exists is read. Files ending in .json contain a JSON object, the others have KEY=value lines.
+var VarsFiles = []string{"gnob.env", "gnob.json"}
+
+// MakeVar is a variable of a Makefile, like the variables of Make.
+// Its value is taken from the first of these that sets it:
+//
+//  1. a `KEY=value` argument on the command line, like `gnob build GOOS=linux`
+//  2. the environment variable with the same name
+//  3. the variables file, like gnob.env
+//  4. the Default of the variable
+//
+// Variables are read from the Body of a target with Makefile.Var, VarBool, VarInt, and VarDuration.
+type MakeVar struct {
+	// Name is the name of the variable, like GOOS.
+	Name string
+	// Default is the value of the variable when it is not set.
+	Default string
+	// Usage is a short description of the variable.
+	// It is shown when running `gnob -help`.
+	Usage string
+}
+
+// varName matches the names of the variables that can be set on the command line.
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// AddVars declares variables of the Makefile, so that they are listed by `gnob -help` with their default value.
+// Variables that are not declared can be set and read too, but have no default value.
+func (mf *Makefile) AddVars(vars ...MakeVar) {
+	for _, v := range vars {
+		mf.vars = slices.DeleteFunc(mf.vars, func(old MakeVar) bool {
+			return old.Name == v.Name
+		})
+		mf.vars = append(mf.vars, v)
+	}
+	slices.SortFunc(mf.vars, func(a, b MakeVar) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// parseVar records a `KEY=value` argument as the value of a variable given on the command line.
+// It returns false if the argument does not set a variable.
+func (mf *Makefile) parseVar(arg string) bool {
+	name, value, ok := strings.Cut(arg, "=")
+	if !ok || !varName.MatchString(name) {
+		return false
+	}
+	if mf.cmdVars == nil {
+		mf.cmdVars = make(map[string]string)
+	}
+	mf.cmdVars[name] = value
+	return true
+}
+
+// loadVars reads the variables file. If file is empty, the first of VarsFiles that exists is read, if any.
+func (mf *Makefile) loadVars(file string) error {
+	mf.fileVars = nil
+	if file == "" {
+		for _, f := range VarsFiles {
+			if _, err := os.Stat(f); err == nil {
+				file = f
+				break
+			}
+		}
+		if file == "" {
+			return nil
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("unable to read variables file %q: %w", file, err)
+	}
+	vars := make(map[string]string)
+	if filepath.Ext(file) == ".json" {
+		var values map[string]json.RawMessage
+		if err = json.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("unable to decode variables file %q: %w", file, err)
+		}
+		for k, v := range values {
+			// strings are decoded, while numbers and booleans are kept as written, so 1000000 is not 1e+06
+			switch v[0] {
+			case '"':
+				var s string
+				if err = json.Unmarshal(v, &s); err != nil {
+					return fmt.Errorf("unable to decode variables file %q: %w", file, err)
+				}
+				vars[k] = s
+			case '{', '[':
+				return fmt.Errorf("variables file %q: variable %s: expected a string, number, or boolean", file, k)
+			case 'n':
+				vars[k] = ""
+			default:
+				vars[k] = string(v)
+			}
+		}
+	} else {
+		bs := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; bs.Scan(); n++ {
+			line := strings.TrimSpace(bs.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+			name = strings.TrimSpace(name)
+			if !ok || !varName.MatchString(name) {
+				return fmt.Errorf("variables file %q: line %d: expected KEY=value", file, n)
+			}
+			value = strings.TrimSpace(value)
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+				value = value[1 : len(value)-1]
+			}
+			vars[name] = value
+		}
+		if err = bs.Err(); err != nil {
+			return fmt.Errorf("unable to read variables file %q: %w", file, err)
+		}
+	}
+	Logger.Debug("[gnob:makefile] loaded variables", "file", file, "count", len(vars))
+	mf.fileVars = vars
+	return nil
+}
+
+// lookupVar returns the value of the variable with the given name, and where it comes from:
+// "command line", "environment", "file", or "default".
+// It returns false if the variable is not set and not declared.
+func (mf *Makefile) lookupVar(name string) (string, string, bool) {
+	if v, ok := mf.cmdVars[name]; ok {
+		return v, "command line", true
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v, "environment", true
+	}
+	if v, ok := mf.fileVars[name]; ok {
+		return v, "file", true
+	}
+	for _, v := range mf.vars {
+		if v.Name == name {
+			return v.Default, "default", true
+		}
+	}
+	return "", "", false
+}
+
+// Var returns the value of the variable with the given name, or an empty string if it is not set.
+func (mf *Makefile) Var(name string) string {
+	v, _, _ := mf.lookupVar(name)
+	return v
+}
+
+// VarBool returns the value of the variable with the given name as a boolean, like "true" or "1".
+// It returns false if the variable is not set, or is not a boolean.
+func (mf *Makefile) VarBool(name string) bool {
+	s := mf.Var(name)
+	if s == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		Logger.Warn("[gnob:makefile] variable is not a boolean", "name", name, "value", s)
+	}
+	return b
+}
+
+// VarInt returns the value of the variable with the given name as an integer.
+// It returns 0 if the variable is not set, or is not an integer.
+func (mf *Makefile) VarInt(name string) int {
+	s := mf.Var(name)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		Logger.Warn("[gnob:makefile] variable is not an integer", "name", name, "value", s)
+	}
+	return n
+}
+
+// VarDuration returns the value of the variable with the given name as a duration, like "1m30s".
+// It returns 0 if the variable is not set, or is not a duration.
+func (mf *Makefile) VarDuration(name string) time.Duration {
+	s := mf.Var(name)
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		Logger.Warn("[gnob:makefile] variable is not a duration", "name", name, "value", s)
+	}
+	return d
+}
+
+// Vars returns the values of the declared variables, and of the variables set on the command line or in the
+// variables file. It can be passed to Cmd.WithEnvVars, to make the variables available to commands.
+func (mf *Makefile) Vars() map[string]string {
+	vars := make(map[string]string)
+	for _, v := range mf.vars {
+		vars[v.Name] = mf.Var(v.Name)
+	}
+	for name := range mf.fileVars {
+		vars[name] = mf.Var(name)
+	}
+	for name, v := range mf.cmdVars {
+		vars[name] = v
+	}
+	return vars
+}
+
+// showVars prints the declared variables with their value, where the value comes from, and their description.
+func (mf *Makefile) showVars() {
+	if len(mf.vars) == 0 {
+		return
+	}
+	fmt.Println("Variables:")
+	entries := make([]string, 0, len(mf.vars))
+	maxLen := 0
+	for _, v := range mf.vars {
+		value, source, _ := mf.lookupVar(v.Name)
+		entry := v.Name + "=" + value
+		if source != "default" {
+			entry += " (" + source + ")"
+		}
+		entries = append(entries, entry)
+		maxLen = max(maxLen, len(entry))
+	}
+	for i, v := range mf.vars {
+		fmt.Printf("  %-*s   %s\n", maxLen, entries[i], v.Usage)
+	}
+}
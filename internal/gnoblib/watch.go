@@ -0,0 +1,191 @@
+package gnoblib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WatchInterval is how often the files are checked for changes in watch mode, with `gnob -watch <target>`.
+// A run starts once the files have not changed for an interval, so that a burst of changes starts a single run.
+var WatchInterval = 500 * time.Millisecond
+
+// watchFiles records files, which may be glob patterns, that the executing target is built from.
+// They are watched in watch mode, like the Inputs of the target.
+// It is called by the up-to-date functions of this package.
+func (mf *Makefile) watchFiles(files ...string) {
+	if mf.run != nil {
+		mf.run.watched = append(mf.run.watched, files...)
+	}
+}
+
+// watchSet is the files watched for changes in watch mode.
+// The outputs are never watched, so that the targets do not trigger a new run by building them.
+type watchSet struct {
+	inputs  []string
+	outputs []string
+}
+
+// fileStamp is the state of a file used to detect changes.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchSet returns the files to watch for the given targets.
+// They are the Inputs of the targets and their dependencies, and the files given to the up-to-date functions
+// of the targets executed during the last run.
+func (mf *Makefile) watchSet(targets []*MakeTarget) watchSet {
+	var (
+		ws      watchSet
+		visited = make(map[*MakeTarget]bool)
+		visit   func(tgt *MakeTarget)
+	)
+	visit = func(tgt *MakeTarget) {
+		if tgt == nil || visited[tgt] {
+			return
+		}
+		visited[tgt] = true
+		ws.inputs = append(ws.inputs, tgt.Inputs...)
+		ws.outputs = append(ws.outputs, tgt.Outputs...)
+		for _, dep := range tgt.Deps {
+			visit(mf.Find(dep))
+		}
+	}
+	for _, tgt := range targets {
+		visit(tgt)
+	}
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	for tgt, run := range mf.runs {
+		ws.inputs = append(ws.inputs, tgt.Inputs...)
+		ws.inputs = append(ws.inputs, run.watched...)
+		ws.outputs = append(ws.outputs, tgt.Outputs...)
+	}
+	return ws
+}
+
+// snapshot returns the state of the watched files.
+func (ws watchSet) snapshot() map[string]fileStamp {
+	outputs := make(map[string]bool)
+	for _, pattern := range ws.outputs {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			outputs[m] = true
+		}
+	}
+	files := make(map[string]fileStamp)
+	for _, pattern := range ws.inputs {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			if outputs[m] {
+				continue
+			}
+			if fi, err := os.Stat(m); err == nil {
+				files[m] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+			}
+		}
+	}
+	return files
+}
+
+// changedFile returns the first file, in lexical order, that was added, removed, or modified between two snapshots.
+// It returns an empty string if no file changed.
+func changedFile(before, after map[string]fileStamp) string {
+	var changed []string
+	for name, stamp := range after {
+		if old, ok := before[name]; !ok || !old.modTime.Equal(stamp.modTime) || old.size != stamp.size {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+	return slices.Min(changed)
+}
+
+// resetRuns forgets the targets executed during the last run, so that they are executed again.
+func (mf *Makefile) resetRuns() {
+	mf.runsMu.Lock()
+	defer mf.runsMu.Unlock()
+	mf.runs = nil
+	mf.edges = nil
+	mf.finished = nil
+}
+
+// watch executes the targets, and executes them again each time the files they are built from change,
+// until the context is cancelled.
+// A change during a run cancels the context of the run, and a new run starts once the files stop changing.
+// The summary of each run is printed, and failed runs are logged without stopping watch mode.
+func (mf *Makefile) watch(ctx context.Context, targets []*MakeTarget) error {
+	names := make([]string, 0, len(targets))
+	for _, tgt := range targets {
+		names = append(names, tgt.Name)
+	}
+	ws := mf.watchSet(targets)
+	snap := ws.snapshot()
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- mf.runTargets(runCtx, targets)
+		}()
+		var changed string
+		running, quiet := true, false
+		for running || changed == "" || !quiet {
+			select {
+			case <-ctx.Done():
+				cancel()
+				if running {
+					<-done
+				}
+				return nil
+			case err := <-done:
+				running = false
+				if err != nil && !errors.Is(err, context.Canceled) {
+					Logger.Error("[gnob:watch] error running build target", "error", err)
+				}
+				mf.showSummary()
+				next := mf.watchSet(targets)
+				if file := changedFile(snap, ws.snapshot()); file != "" && changed == "" {
+					changed = file
+				}
+				ws = next
+				snap = ws.snapshot()
+				mf.resetRuns()
+				Logger.Debug("[gnob:watch] watching files", "files", len(snap))
+			case <-ticker.C:
+				next := ws.snapshot()
+				file := changedFile(snap, next)
+				snap = next
+				if file != "" {
+					if changed == "" {
+						changed = file
+					}
+					quiet = false
+					continue
+				}
+				quiet = changed != ""
+				if quiet && running && runCtx.Err() == nil {
+					Logger.Info("[gnob:watch] cancelling the current run", "changed", changed)
+					cancel()
+				}
+			}
+		}
+		cancel()
+		_, _ = fmt.Fprintf(os.Stderr, "\n===== [gnob] %s changed, running %s again (run %d) =====\n\n",
+			changed, strings.Join(names, " "), n+1)
+	}
+}
@@ -0,0 +1,1645 @@
+package gnobtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justenwalker/gnob/internal/gnoblib"
+)
+
+func TestMakefileDependParallel(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	body := func(ctx context.Context, mf *gnoblib.Makefile) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-j", "2", "all"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.DependParallel(ctx, "a", "b", "c", "d")
+			},
+		},
+		gnoblib.MakeTarget{Name: "a", Body: body},
+		gnoblib.MakeTarget{Name: "b", Body: body},
+		gnoblib.MakeTarget{Name: "c", Body: body},
+		gnoblib.MakeTarget{Name: "d", Body: body},
+	)
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if got := mf.Jobs(); got != 2 {
+		t.Errorf("Jobs() = %d, want 2", got)
+	}
+	if got := maxRunning.Load(); got != 2 {
+		t.Errorf("max concurrent targets = %d, want 2", got)
+	}
+}
+
+func TestMakefileDependParallelNested(t *testing.T) {
+	for _, jobs := range []int32{1, 2} {
+		t.Run(fmt.Sprint("j", jobs), func(t *testing.T) {
+			var running, maxRunning atomic.Int32
+			leaf := func(ctx context.Context, mf *gnoblib.Makefile) error {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					m := maxRunning.Load()
+					if n <= m || maxRunning.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			}
+			parallel := func(names ...string) func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return func(ctx context.Context, mf *gnoblib.Makefile) error {
+					return mf.DependParallel(ctx, names...)
+				}
+			}
+			mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-j", fmt.Sprint(jobs), "all"},
+				gnoblib.MakeTarget{Name: "all", Body: parallel("x", "y")},
+				gnoblib.MakeTarget{Name: "x", Body: parallel("a", "b", "shared")},
+				gnoblib.MakeTarget{Name: "y", Body: parallel("c", "d", "shared")},
+				gnoblib.MakeTarget{Name: "a", Body: leaf},
+				gnoblib.MakeTarget{Name: "b", Body: leaf},
+				gnoblib.MakeTarget{Name: "c", Body: leaf},
+				gnoblib.MakeTarget{Name: "d", Body: leaf},
+				gnoblib.MakeTarget{Name: "shared", Body: leaf},
+			)
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+			if err := mf.RunE(ctx); err != nil {
+				t.Fatalf("RunE() error = %v", err)
+			}
+			if got := maxRunning.Load(); got != jobs {
+				t.Errorf("max concurrent targets = %d, want %d", got, jobs)
+			}
+		})
+	}
+}
+
+func TestMakefileSetJobsWhileRunning(t *testing.T) {
+	noop := func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-j", "2", "all"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.DependParallel(ctx, "x")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "x",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				mf.SetJobs(1)
+				return mf.DependParallel(ctx, "a", "b")
+			},
+		},
+		gnoblib.MakeTarget{Name: "a", Body: noop},
+		gnoblib.MakeTarget{Name: "b", Body: noop},
+	)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	if err := mf.RunE(ctx); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if got := mf.Jobs(); got != 1 {
+		t.Errorf("Jobs() = %d, want 1", got)
+	}
+}
+
+func TestMakefileDependParallelCancel(t *testing.T) {
+	errFail := errors.New("fail")
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-j4", "all"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.DependParallel(ctx, "fail", "slow")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "fail",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return errFail
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "slow",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(5 * time.Second):
+					return nil
+				}
+			},
+		},
+	)
+	err := mf.RunE(t.Context())
+	if !errors.Is(err, errFail) {
+		t.Errorf("RunE() error = %v, want %v", err, errFail)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunE() error = %v, want sibling to be cancelled", err)
+	}
+}
+
+func TestMakefileRunOnce(t *testing.T) {
+	errFail := errors.New("fail")
+	var shared, failing atomic.Int32
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"all"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				if err := mf.DependParallel(ctx, "a", "b", "shared"); err != nil {
+					return err
+				}
+				return mf.Depend(ctx, "failing", "failing")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "a",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.Depend(ctx, "shared")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "b",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.Depend(ctx, "shared")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "shared",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				shared.Add(1)
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "failing",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				failing.Add(1)
+				return errFail
+			},
+		},
+	)
+	if err := mf.RunE(t.Context()); !errors.Is(err, errFail) {
+		t.Fatalf("RunE() error = %v, want %v", err, errFail)
+	}
+	if got := shared.Load(); got != 1 {
+		t.Errorf("shared executed %d times, want 1", got)
+	}
+	if got := failing.Load(); got != 1 {
+		t.Errorf("failing executed %d times, want 1", got)
+	}
+	if err := mf.RunE(t.Context()); !errors.Is(err, errFail) {
+		t.Fatalf("RunE() error = %v, want %v", err, errFail)
+	}
+	if got := shared.Load(); got != 2 {
+		t.Errorf("shared executed %d times after second run, want 2", got)
+	}
+}
+
+func TestMakefileDependCycle(t *testing.T) {
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"a"},
+		gnoblib.MakeTarget{
+			Name: "a",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.Depend(ctx, "b")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "b",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.Depend(ctx, "a")
+			},
+		},
+	)
+	err := mf.RunE(t.Context())
+	if err == nil || err.Error() != "dependency cycle: a -> b -> a" {
+		t.Errorf("RunE() error = %v, want dependency cycle", err)
+	}
+}
+
+func TestMakefileDependCycleParallel(t *testing.T) {
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-j", "4", "a"},
+		gnoblib.MakeTarget{
+			Name: "a",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.DependParallel(ctx, "b", "c")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "b",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				time.Sleep(20 * time.Millisecond)
+				return mf.Depend(ctx, "c")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name: "c",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				time.Sleep(20 * time.Millisecond)
+				return mf.Depend(ctx, "b")
+			},
+		},
+	)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	err := mf.RunE(ctx)
+	// b and c both wait for the other, so the cycle is found from either of them
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle: b -> c -> b") &&
+		!strings.Contains(err.Error(), "dependency cycle: c -> b -> c") {
+		t.Errorf("RunE() error = %v, want dependency cycle", err)
+	}
+}
+
+func TestMakefileDeps(t *testing.T) {
+	var order []string
+	body := func(name string) func(ctx context.Context, mf *gnoblib.Makefile) error {
+		return func(ctx context.Context, mf *gnoblib.Makefile) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"all"},
+		gnoblib.MakeTarget{Name: "all", Deps: []string{"b", "a"}, Body: body("all")},
+		gnoblib.MakeTarget{Name: "a", Deps: []string{"c"}, Body: body("a")},
+		gnoblib.MakeTarget{Name: "b", Deps: []string{"c"}, Body: body("b")},
+	)
+	mf.Add(gnoblib.MakeTarget{Name: "c", Body: body("c")})
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	want := []string{"c", "b", "a", "all"}
+	if !slices.Equal(order, want) {
+		t.Errorf("execution order = %v, want %v", order, want)
+	}
+}
+
+func TestMakefileDepsInvalid(t *testing.T) {
+	noop := func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }
+	tests := []struct {
+		name    string
+		targets []gnoblib.MakeTarget
+		wantErr string
+	}{
+		{
+			name: "unknown",
+			targets: []gnoblib.MakeTarget{
+				{Name: "a", Deps: []string{"missing"}, Body: noop},
+			},
+			wantErr: "target a: unknown dependency: missing",
+		},
+		{
+			name: "cycle",
+			targets: []gnoblib.MakeTarget{
+				{Name: "a", Deps: []string{"b"}, Body: noop},
+				{Name: "b", Deps: []string{"c"}, Body: noop},
+				{Name: "c", Deps: []string{"a"}, Body: noop},
+			},
+			wantErr: "dependency cycle: a -> b -> c -> a",
+		},
+		{
+			name: "self",
+			targets: []gnoblib.MakeTarget{
+				{Name: "a", Deps: []string{"a"}, Body: noop},
+			},
+			wantErr: "dependency cycle: a -> a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"a"}, tt.targets...)
+			err := mf.RunE(t.Context())
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("RunE() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMakefileGraph(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("test.out", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	noop := func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"all"},
+		gnoblib.MakeTarget{
+			Name:    "all",
+			Default: true,
+			Deps:    []string{"build"},
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.Depend(ctx, "build", "test")
+			},
+		},
+		gnoblib.MakeTarget{
+			Name:     "build",
+			UpToDate: func(mf *gnoblib.Makefile) bool { return true },
+			Body:     noop,
+		},
+		gnoblib.MakeTarget{Name: "test", Hidden: true, Outputs: []string{"test.out"}, Body: noop},
+	)
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	var sb strings.Builder
+	if err := mf.Graph().WriteDOT(&sb); err != nil {
+		t.Fatalf("WriteDOT() error = %v", err)
+	}
+	want := `digraph gnob {
+  node [shape=box];
+  "all" [style="bold"];
+  "build" [style="filled"];
+  "test" [style="dashed,filled"];
+  "all" -> "build";
+  "all" -> "test" [style=dashed];
+}
+`
+	if got := sb.String(); got != want {
+		t.Errorf("WriteDOT() = %s, want %s", got, want)
+	}
+
+	var runs int
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"all"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				if runs++; runs == 1 {
+					return mf.Depend(ctx, "test")
+				}
+				return nil
+			},
+		},
+		gnoblib.MakeTarget{Name: "test", Body: noop},
+	)
+	for range 2 {
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Fatalf("RunE() error = %v", err)
+		}
+	}
+	if edges := mf.Graph().Edges; len(edges) != 0 {
+		t.Errorf("Graph().Edges = %v, want no edges from the previous run", edges)
+	}
+}
+
+func TestMakefileHashUpToDate(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFile := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile(%q) error = %v", name, err)
+		}
+	}
+	writeFile("src.txt", "hello")
+	var builds int
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"out.txt"},
+		gnoblib.MakeTarget{
+			Name:     "out.txt",
+			UpToDate: gnoblib.Lib.Makefile.HashUpToDate("out.txt", "src*.txt"),
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				builds++
+				return gnoblib.Lib.Files.CopyFile("out.txt", "src.txt", 0)
+			},
+		},
+	)
+	run := func(wantBuilds int) {
+		t.Helper()
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Fatalf("RunE() error = %v", err)
+		}
+		if builds != wantBuilds {
+			t.Errorf("builds = %d, want %d", builds, wantBuilds)
+		}
+	}
+	run(1)
+	run(1)
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes("src.txt", future, future); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	run(1)
+	writeFile("src.txt", "world")
+	run(2)
+	run(2)
+	writeFile("out.txt", "modified")
+	run(3)
+}
+
+func TestMakefileStateUpToDate(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("input.go", []byte("package main"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	errFail := errors.New("fail")
+	var runs int
+	var fail bool
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"test"},
+		gnoblib.MakeTarget{
+			Name:     "test",
+			UpToDate: gnoblib.Lib.Makefile.StateUpToDate([]string{"*.go"}, nil),
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				runs++
+				if fail {
+					return errFail
+				}
+				return nil
+			},
+		},
+	)
+	if _, ok := mf.State("test"); ok {
+		t.Fatalf("State() found state before the first run")
+	}
+	fail = true
+	if err := mf.RunE(t.Context()); !errors.Is(err, errFail) {
+		t.Fatalf("RunE() error = %v, want %v", err, errFail)
+	}
+	state, ok := mf.State("test")
+	if !ok || state.Result != gnoblib.TargetFailed || state.Error != "fail" {
+		t.Errorf("State() = %+v, %v, want failed state", state, ok)
+	}
+	fail = false
+	for range 2 {
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Fatalf("RunE() error = %v", err)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("runs = %d, want 2", runs)
+	}
+	state, ok = mf.State("test")
+	if !ok || state.Result != gnoblib.TargetSucceeded || len(state.Inputs) != 1 || state.LastRun.IsZero() {
+		t.Errorf("State() = %+v, %v, want succeeded state", state, ok)
+	}
+}
+
+func TestMakefileInputsOutputs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("src.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	var builds int
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"out.txt"},
+		gnoblib.MakeTarget{
+			Name:    "out.txt",
+			Inputs:  []string{"src*.txt"},
+			Outputs: []string{"out.txt"},
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				builds++
+				return gnoblib.Lib.Files.CopyFile("out.txt", "src.txt", 0)
+			},
+		},
+		gnoblib.MakeTarget{
+			Name:    "missing",
+			Outputs: []string{"missing.txt"},
+			Body:    func(ctx context.Context, mf *gnoblib.Makefile) error { return nil },
+		},
+	)
+	run := func(wantBuilds int) {
+		t.Helper()
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Fatalf("RunE() error = %v", err)
+		}
+		if builds != wantBuilds {
+			t.Errorf("builds = %d, want %d", builds, wantBuilds)
+		}
+	}
+	run(1)
+	run(1)
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("out.txt", past, past); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	run(2)
+	run(2)
+	if err := mf.Depend(t.Context(), "missing"); err == nil || !strings.Contains(err.Error(), "missing output: missing.txt") {
+		t.Errorf("Depend() error = %v, want missing output", err)
+	}
+}
+
+func TestMakefilePatternTarget(t *testing.T) {
+	var built []string
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"all"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Deps: []string{"bin/a", "bin/b", "bin/a"},
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return nil },
+		},
+	)
+	if err := mf.RunE(t.Context()); err == nil {
+		t.Fatalf("RunE() error = nil, want unknown dependency")
+	}
+	mf.AddPattern(
+		gnoblib.PatternTarget{
+			Name: "bin/%",
+			Deps: []string{"gen/%"},
+			Body: func(ctx context.Context, mf *gnoblib.Makefile, stem string) error {
+				built = append(built, "bin:"+stem)
+				return nil
+			},
+		},
+		gnoblib.PatternTarget{
+			Name: "%",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile, stem string) error {
+				built = append(built, "any:"+stem)
+				return nil
+			},
+		},
+	)
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	want := []string{"any:gen/a", "bin:a", "any:gen/b", "bin:b"}
+	if !slices.Equal(built, want) {
+		t.Errorf("built = %v, want %v", built, want)
+	}
+	if tgt := mf.Find("bin/c"); tgt == nil || tgt.Name != "bin/c" || !slices.Equal(tgt.Deps, []string{"gen/c"}) {
+		t.Errorf("Find() = %+v, want bin/c depending on gen/c", tgt)
+	}
+}
+
+func TestMakefileTargetFlags(t *testing.T) {
+	type result struct {
+		race    bool
+		tags    string
+		count   int
+		timeout time.Duration
+		args    []string
+	}
+	var got []result
+	newMakefile := func(args ...string) *gnoblib.Makefile {
+		return gnoblib.Lib.Makefile.NewEx("gnob", args,
+			gnoblib.MakeTarget{
+				Name: "build",
+				Deps: []string{"gen"},
+				Flags: []gnoblib.TargetFlag{
+					gnoblib.Lib.Makefile.BoolFlag("race", false, "enable the race detector"),
+					gnoblib.Lib.Makefile.StringFlag("tags", "gnob", "build tags"),
+					gnoblib.Lib.Makefile.IntFlag("count", 1, "number of builds"),
+					gnoblib.Lib.Makefile.DurationFlag("timeout", time.Minute, "build timeout"),
+				},
+				Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+					got = append(got, result{mf.Bool("race"), mf.String("tags"), mf.Int("count"), mf.Duration("timeout"), mf.TargetArgs()})
+					return nil
+				},
+			},
+			gnoblib.MakeTarget{
+				Name:  "gen",
+				Flags: []gnoblib.TargetFlag{gnoblib.Lib.Makefile.BoolFlag("race", true, "unused")},
+				Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+					got = append(got, result{race: mf.Bool("race"), tags: mf.String("tags")})
+					return nil
+				},
+			},
+			gnoblib.MakeTarget{
+				Name: "test",
+				Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+					got = append(got, result{args: mf.TargetArgs()})
+					return nil
+				},
+			},
+		)
+	}
+	if err := newMakefile("build", "-race", "-tags=foo", "-count", "3", "pkg").RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	want := []result{
+		{race: true},
+		{race: true, tags: "foo", count: 3, timeout: time.Minute, args: []string{"pkg"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].race != want[i].race || got[i].tags != want[i].tags || got[i].count != want[i].count ||
+			got[i].timeout != want[i].timeout || !slices.Equal(got[i].args, want[i].args) {
+			t.Errorf("result[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if err := newMakefile("build", "-count=many").RunE(t.Context()); err == nil {
+		t.Errorf("RunE() with invalid flag value error = nil, want error")
+	}
+	if err := newMakefile("build", "-unknown").RunE(t.Context()); err == nil {
+		t.Errorf("RunE() with unknown flag error = nil, want error")
+	}
+	got = nil
+	if err := newMakefile("test", "-v", "./...").RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if len(got) != 1 || !slices.Equal(got[0].args, []string{"-v", "./..."}) {
+		t.Errorf("results = %+v, want the arguments of the target without flags as given", got)
+	}
+}
+
+func TestMakefileMultipleTargets(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	body := func(ctx context.Context, mf *gnoblib.Makefile) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, mf.TargetArgs()...)
+		return nil
+	}
+	newMakefile := func(args ...string) *gnoblib.Makefile {
+		return gnoblib.Lib.Makefile.NewEx("gnob", args,
+			gnoblib.MakeTarget{
+				Name:  "build",
+				Flags: []gnoblib.TargetFlag{gnoblib.Lib.Makefile.StringFlag("tags", "", "build tags")},
+				Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+					mu.Lock()
+					got = append(got, "build:"+mf.String("tags"))
+					mu.Unlock()
+					return body(ctx, mf)
+				},
+			},
+			gnoblib.MakeTarget{Name: "test", Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				mu.Lock()
+				got = append(got, "test")
+				mu.Unlock()
+				return body(ctx, mf)
+			}},
+			gnoblib.MakeTarget{Name: "example", Deps: []string{"test"}, Body: body},
+		)
+	}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{args: []string{"test", "example"}, want: []string{"test"}},
+		{args: []string{"build", "-tags", "test", "pkg", "test", "a", "b"}, want: []string{"build:test", "pkg", "test", "a", "b"}},
+		{args: []string{"test", "--", "build", "example"}, want: []string{"test", "build", "example"}},
+		{args: []string{"build", "--", "-tags", "x"}, want: []string{"build:", "-tags", "x"}},
+	}
+	for _, tt := range tests {
+		got = nil
+		if err := newMakefile(tt.args...).RunE(t.Context()); err != nil {
+			t.Fatalf("RunE(%q) error = %v", tt.args, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("RunE(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	got = nil
+	if err := newMakefile("-parallel", "build", "test").RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	slices.Sort(got)
+	if want := []string{"build:", "test"}; !slices.Equal(got, want) {
+		t.Errorf("RunE(-parallel) = %q, want %q", got, want)
+	}
+	if err := newMakefile("test", "unknown").RunE(t.Context()); err != nil {
+		t.Errorf("RunE() error = %v, want unknown to be an argument", err)
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan string)
+	go func() {
+		var sb strings.Builder
+		_, _ = io.Copy(&sb, r)
+		done <- sb.String()
+	}()
+	fn()
+	_ = w.Close()
+	return <-done
+}
+
+func TestMakefileOptions(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(t.TempDir())
+	noop := func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }
+	newMakefile := func(args ...string) *gnoblib.Makefile {
+		return gnoblib.Lib.Makefile.NewEx("gnob", args,
+			gnoblib.MakeTarget{
+				Name:    "build",
+				Desc:    "Build it",
+				Default: true,
+				Deps:    []string{"gen"},
+				Flags:   []gnoblib.TargetFlag{gnoblib.Lib.Makefile.BoolFlag("race", false, "race detector")},
+				Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+					return os.WriteFile("out.txt", nil, 0o644)
+				},
+			},
+			gnoblib.MakeTarget{Name: "gen", Desc: "Generate", Body: noop},
+			gnoblib.MakeTarget{Name: "secret", Hidden: true, Body: noop},
+		)
+	}
+	if err := newMakefile("-C", dir, "--j=2", "build").RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if _, err := os.Stat("out.txt"); err != nil {
+		t.Errorf("Stat() error = %v, want out.txt in -C directory", err)
+	}
+	mf := newMakefile("-C", dir, "-j4", "build")
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE(-C dir -j4) error = %v", err)
+	}
+	if got := mf.Jobs(); got != 4 {
+		t.Errorf("Jobs() = %d, want 4", got)
+	}
+	out := captureStdout(t, func() {
+		if err := newMakefile("--list").RunE(t.Context()); err != nil {
+			t.Errorf("RunE(--list) error = %v", err)
+		}
+	})
+	if want := "build\tBuild it\ngen\tGenerate\n"; out != want {
+		t.Errorf("RunE(--list) = %q, want %q", out, want)
+	}
+	out = captureStdout(t, func() {
+		if err := newMakefile("-list=json").RunE(t.Context()); err != nil {
+			t.Errorf("RunE(-list=json) error = %v", err)
+		}
+	})
+	var entries []struct {
+		Name    string   `json:"name"`
+		Default bool     `json:"default"`
+		Deps    []string `json:"deps"`
+		Flags   []string `json:"flags"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "build" || !entries[0].Default ||
+		!slices.Equal(entries[0].Deps, []string{"gen"}) || !slices.Equal(entries[0].Flags, []string{"race"}) {
+		t.Errorf("RunE(-list=json) = %+v", entries)
+	}
+	for _, args := range [][]string{{"-h"}, {"--help", "build"}, {"build", "-help"}} {
+		out = captureStdout(t, func() {
+			if err := newMakefile(args...).RunE(t.Context()); err != nil {
+				t.Errorf("RunE(%q) error = %v", args, err)
+			}
+		})
+		if !strings.Contains(out, "build") {
+			t.Errorf("RunE(%q) = %q, want help", args, out)
+		}
+	}
+	out = captureStdout(t, func() {
+		if err := newMakefile("-h").RunE(t.Context()); err != nil {
+			t.Errorf("RunE(-h) error = %v", err)
+		}
+	})
+	if strings.Contains(out, "-list json") || strings.Contains(out, "-graph dot") || !strings.Contains(out, "-list=json") {
+		t.Errorf("RunE(-h) = %q, want -list=json and no separate format argument", out)
+	}
+	if err := newMakefile("-unknown").RunE(t.Context()); err == nil {
+		t.Errorf("RunE(-unknown) error = nil, want error")
+	}
+}
+
+func TestMakefileDryRun(t *testing.T) {
+	fail := func(ctx context.Context, mf *gnoblib.Makefile) error {
+		t.Errorf("Body() called in dry-run mode")
+		return errors.New("called")
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-n", "all"},
+		gnoblib.MakeTarget{
+			Name:         "all",
+			Deps:         []string{"gen", "fresh"},
+			EchoCommands: true,
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				if !mf.DryRun() {
+					t.Errorf("DryRun() = false, want true")
+				}
+				if err := mf.DependParallel(ctx, "test"); err != nil {
+					return err
+				}
+				return gnoblib.Lib.Cmd.ExecOpt(ctx, gnoblib.Lib.Cmd.WithDir("sub dir"),
+					"gnob-does-not-exist", "-v", "it's").Pipe("wc", "-l").Run()
+			},
+		},
+		gnoblib.MakeTarget{Name: "gen", Body: fail},
+		gnoblib.MakeTarget{Name: "fresh", UpToDate: func(mf *gnoblib.Makefile) bool { return true }, Body: fail},
+		gnoblib.MakeTarget{Name: "test", Body: fail},
+	)
+	out := captureStdout(t, func() {
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Errorf("RunE() error = %v", err)
+		}
+	})
+	want := "gen\nfresh (up-to-date)\nall\ntest\n  cd 'sub dir' && gnob-does-not-exist -v 'it'\\''s' | wc -l\n"
+	if out != want {
+		t.Errorf("RunE(-n) output = %q, want %q", out, want)
+	}
+}
+
+func TestMakefileDryRunChain(t *testing.T) {
+	t.Chdir(t.TempDir())
+	now := time.Now()
+	for i, name := range []string{"a.out", "b.out", "src.txt"} {
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		ts := now.Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(name, ts, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fail := func(ctx context.Context, mf *gnoblib.Makefile) error {
+		t.Errorf("Body() called in dry-run mode")
+		return errors.New("called")
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-n", "c", "fresh"},
+		gnoblib.MakeTarget{Name: "a", Inputs: []string{"src.txt"}, Outputs: []string{"a.out"}, Body: fail},
+		gnoblib.MakeTarget{Name: "b", Deps: []string{"a"}, Inputs: []string{"a.out"}, Outputs: []string{"b.out"}, Body: fail},
+		gnoblib.MakeTarget{Name: "c", Deps: []string{"b"}, UpToDate: func(mf *gnoblib.Makefile) bool { return true }, Body: fail},
+		gnoblib.MakeTarget{Name: "fresh", Inputs: []string{"a.out"}, Outputs: []string{"b.out"}, Body: fail},
+	)
+	out := captureStdout(t, func() {
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Errorf("RunE() error = %v", err)
+		}
+	})
+	// b and c are up-to-date with the current files, but would run after a
+	if want := "a\nb\nc\nfresh (up-to-date)\n"; out != want {
+		t.Errorf("RunE(-n) output = %q, want %q", out, want)
+	}
+}
+
+func TestMakefileKeepGoing(t *testing.T) {
+	errFail := errors.New("fail")
+	var (
+		mu  sync.Mutex
+		ran []string
+	)
+	newMakefile := func(args ...string) *gnoblib.Makefile {
+		track := func(name string) func(ctx context.Context, mf *gnoblib.Makefile) error {
+			return func(ctx context.Context, mf *gnoblib.Makefile) error {
+				mu.Lock()
+				defer mu.Unlock()
+				ran = append(ran, name)
+				return nil
+			}
+		}
+		return gnoblib.Lib.Makefile.NewEx("gnob", args,
+			gnoblib.MakeTarget{Name: "all", Deps: []string{"broken", "app", "other"}, Body: track("all")},
+			gnoblib.MakeTarget{Name: "app", Deps: []string{"broken"}, Body: track("app")},
+			gnoblib.MakeTarget{Name: "broken", Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return errFail
+			}},
+			gnoblib.MakeTarget{Name: "other", Deps: []string{"lib"}, Body: track("other")},
+			gnoblib.MakeTarget{Name: "lib", Body: track("lib")},
+		)
+	}
+	if err := newMakefile("all").RunE(t.Context()); !errors.Is(err, errFail) {
+		t.Fatalf("RunE() error = %v, want %v", err, errFail)
+	}
+	if len(ran) != 0 {
+		t.Errorf("ran = %v, want nothing without -k", ran)
+	}
+	err := newMakefile("-k", "all", "lib").RunE(t.Context())
+	if !errors.Is(err, errFail) {
+		t.Fatalf("RunE(-k) error = %v, want %v", err, errFail)
+	}
+	if got := strings.Count(err.Error(), "fail"); got != 1 {
+		t.Errorf("RunE(-k) error = %q, want the failure reported once", err)
+	}
+	if want := []string{"lib", "other"}; !slices.Equal(ran, want) {
+		t.Errorf("ran = %v, want %v", ran, want)
+	}
+}
+
+func TestMakefileSummary(t *testing.T) {
+	errFail := errors.New("fail")
+	sleep := func(ctx context.Context, mf *gnoblib.Makefile) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-k", "all"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Deps: []string{"fast", "slow", "fresh"},
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				return mf.Depend(ctx, "broken", "after")
+			},
+		},
+		gnoblib.MakeTarget{Name: "fast", Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }},
+		gnoblib.MakeTarget{Name: "slow", Deps: []string{"fast"}, Body: sleep},
+		gnoblib.MakeTarget{Name: "fresh", UpToDate: func(mf *gnoblib.Makefile) bool { return true }, Body: sleep},
+		gnoblib.MakeTarget{Name: "broken", Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return errFail }},
+		gnoblib.MakeTarget{Name: "after", Deps: []string{"broken"}, Body: sleep},
+	)
+	if err := mf.RunE(t.Context()); !errors.Is(err, errFail) {
+		t.Fatalf("RunE() error = %v, want %v", err, errFail)
+	}
+	s := mf.Summary()
+	var got []string
+	for _, ts := range s.Targets {
+		got = append(got, ts.Name+":"+ts.Result)
+	}
+	want := []string{"fast:success", "slow:success", "fresh:up-to-date", "broken:failure", "after:skipped", "all:failure"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Summary().Targets = %v, want %v", got, want)
+	}
+	if s.Targets[1].Duration < 20*time.Millisecond || s.Targets[4].Duration != 0 || s.Targets[3].Error != "fail" {
+		t.Errorf("Summary().Targets = %+v", s.Targets)
+	}
+	if want := []string{"broken", "after", "all"}; !slices.Equal(s.CriticalPath, want) {
+		t.Errorf("Summary().CriticalPath = %v, want %v", s.CriticalPath, want)
+	}
+	var sb strings.Builder
+	if err := s.WriteTable(&sb); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	if !strings.Contains(sb.String(), "after    skipped      0s") || !strings.Contains(sb.String(), "Critical path: broken -> after -> all") {
+		t.Errorf("WriteTable() = %s", sb.String())
+	}
+}
+
+func TestMakefileTrace(t *testing.T) {
+	exe, err := filepath.Abs(mainExec(t))
+	if err != nil {
+		t.Fatalf("Abs() error = %v", err)
+	}
+	trace := filepath.Join(t.TempDir(), "trace.json")
+	body := func(ctx context.Context, mf *gnoblib.Makefile) error {
+		return gnoblib.Lib.Cmd.Exec(ctx, exe, "-sleep", "50ms", "-stdout", "x").
+			Pipe(exe, "-stdin2out", "-sleep", "50ms").Run()
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-trace", trace, "-parallel", "a", "b"},
+		gnoblib.MakeTarget{Name: "a", Body: body},
+		gnoblib.MakeTarget{Name: "b", Body: body},
+	)
+	if err = mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	data, err := os.ReadFile(trace)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var tf struct {
+		TraceEvents []struct {
+			Name string `json:"name"`
+			Cat  string `json:"cat"`
+			Ph   string `json:"ph"`
+			Ts   int64  `json:"ts"`
+			Dur  int64  `json:"dur"`
+			Tid  int    `json:"tid"`
+		} `json:"traceEvents"`
+	}
+	if err = json.Unmarshal(data, &tf); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	counts := make(map[string]int)
+	for i, e := range tf.TraceEvents {
+		if e.Ph != "X" {
+			continue
+		}
+		counts[e.Cat]++
+		for _, o := range tf.TraceEvents[i+1:] {
+			if o.Ph == "X" && o.Tid == e.Tid && o.Ts < e.Ts+e.Dur && e.Ts < o.Ts+o.Dur {
+				t.Errorf("spans %s and %s overlap on lane %d", e.Name, o.Name, e.Tid)
+			}
+		}
+	}
+	if counts["target"] != 2 || counts["command"] != 4 {
+		t.Errorf("span counts = %v, want 2 targets and 4 commands", counts)
+	}
+}
+
+func TestMakefileTimeoutRetries(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	var attempts int
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-k", "flaky", "slow", "hung"},
+		gnoblib.MakeTarget{
+			Name:         "flaky",
+			Retries:      3,
+			RetryBackoff: time.Millisecond,
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				attempts++
+				if attempts < 3 {
+					return errFlaky
+				}
+				return nil
+			},
+		},
+		gnoblib.MakeTarget{
+			Name:         "slow",
+			Timeout:      10 * time.Millisecond,
+			Retries:      1,
+			RetryBackoff: time.Millisecond,
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+		gnoblib.MakeTarget{
+			Name:    "hung",
+			Timeout: 10 * time.Millisecond,
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			},
+		},
+	)
+	err := mf.RunE(t.Context())
+	if !errors.Is(err, gnoblib.ErrTargetTimeout) {
+		t.Fatalf("RunE() error = %v, want %v", err, gnoblib.ErrTargetTimeout)
+	}
+	var got []string
+	for _, ts := range mf.Summary().Targets {
+		got = append(got, fmt.Sprintf("%s:%s:%d", ts.Name, ts.Result, ts.Attempts))
+	}
+	want := []string{"flaky:success:3", "slow:timeout:2", "hung:timeout:1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Summary().Targets = %v, want %v", got, want)
+	}
+
+	attempts = 0
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"flaky"},
+		gnoblib.MakeTarget{
+			Name:         "flaky",
+			Retries:      3,
+			RetryBackoff: 2 * time.Second,
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				attempts++
+				return errFlaky
+			},
+		},
+	)
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	err = mf.RunE(ctx)
+	if !errors.Is(err, errFlaky) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RunE() error = %v, want %v and %v", err, errFlaky, context.DeadlineExceeded)
+	}
+	if attempts != 1 {
+		t.Errorf("Body executed %d times, want no retry after the context is done", attempts)
+	}
+}
+
+func TestMakefileHooks(t *testing.T) {
+	errFail := errors.New("fail")
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-k", "all", "broken"},
+		gnoblib.MakeTarget{
+			Name: "all",
+			Deps: []string{"fresh"},
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return nil },
+		},
+		gnoblib.MakeTarget{
+			Name:     "fresh",
+			UpToDate: func(mf *gnoblib.Makefile) bool { return true },
+			Body:     func(ctx context.Context, mf *gnoblib.Makefile) error { return nil },
+		},
+		gnoblib.MakeTarget{
+			Name: "broken",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return errFail },
+		},
+	)
+	var events []string
+	hook := func(kind string) gnoblib.TargetHook {
+		return func(ctx context.Context, ev gnoblib.TargetEvent) {
+			events = append(events, kind+":"+ev.Target.Name+":"+ev.Result)
+		}
+	}
+	mf.OnTargetStart(hook("start"))
+	mf.OnTargetFinish(hook("finish"))
+	mf.OnUpToDate(hook("up-to-date"))
+	mf.OnError(hook("error"))
+	mf.BeforeRun(func(ctx context.Context, targets []*gnoblib.MakeTarget) {
+		events = append(events, fmt.Sprintf("before:%d", len(targets)))
+	})
+	mf.AfterRun(func(ctx context.Context, err error) {
+		events = append(events, fmt.Sprintf("after:%v", errors.Is(err, errFail)))
+	})
+	if err := mf.RunE(t.Context()); !errors.Is(err, errFail) {
+		t.Fatalf("RunE() error = %v, want %v", err, errFail)
+	}
+	want := []string{
+		"before:2",
+		"start:fresh:", "up-to-date:fresh:up-to-date", "finish:fresh:up-to-date",
+		"start:all:", "finish:all:success",
+		"start:broken:", "error:broken:failure", "finish:broken:failure",
+		"after:true",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestMakefileNamespaces(t *testing.T) {
+	var ran []string
+	target := func(name string, deps ...string) gnoblib.MakeTarget {
+		return gnoblib.MakeTarget{
+			Name: name,
+			Desc: name + " target",
+			Deps: deps,
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				ran = append(ran, name)
+				return nil
+			},
+		}
+	}
+	docker := gnoblib.Lib.Makefile.New(
+		target("image"),
+		gnoblib.MakeTarget{
+			Name: "push",
+			Desc: "push target",
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				ran = append(ran, "push")
+				return mf.Depend(ctx, "test")
+			},
+		},
+		target("test", "image"),
+	)
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"push", "go:test"},
+		target("go:test"),
+		target("go:lint"),
+	)
+	mf.Mount("docker", docker)
+	if tgt := mf.Find("docker:test"); tgt == nil || !slices.Equal(tgt.Deps, []string{"docker:image"}) {
+		t.Fatalf("Find(docker:test) = %+v, want Deps [docker:image]", tgt)
+	}
+	if tgt := mf.Find("lint"); tgt == nil || tgt.Name != "go:lint" {
+		t.Errorf("Find(lint) = %+v, want go:lint", tgt)
+	}
+	if tgt := mf.Find("test"); tgt != nil {
+		t.Errorf("Find(test) = %s, want nil for an ambiguous name", tgt.Name)
+	}
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	want := []string{"push", "image", "test", "go:test"}
+	if !slices.Equal(ran, want) {
+		t.Errorf("ran = %q, want %q", ran, want)
+	}
+
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"test"}, target("go:test"))
+	mf.Mount("docker", docker)
+	if err := mf.RunE(t.Context()); err == nil || !strings.Contains(err.Error(), "ambiguous target") {
+		t.Errorf("RunE() error = %v, want ambiguous target", err)
+	}
+
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"-help"}, target("all"), target("go:test"))
+	mf.Mount("docker", docker)
+	out := captureStdout(t, func() {
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Errorf("RunE() error = %v", err)
+		}
+	})
+	_, listing, _ := strings.Cut(out, "Targets:")
+	var headings []string
+	for _, line := range strings.Split(listing, "\n") {
+		if strings.HasSuffix(line, ":") {
+			headings = append(headings, line)
+		}
+	}
+	if want := []string{"docker:", "go:"}; !slices.Equal(headings, want) {
+		t.Errorf("namespace headings = %q, want %q\n%s", headings, want, out)
+	}
+}
+
+func TestMakefileImport(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("..", "..", "gnob.go"))
+	if err != nil {
+		t.Fatalf("unable to read gnob.go: %v", err)
+	}
+	dir := t.TempDir()
+	project := map[string]string{
+		"go.mod":  "module sub\n\ngo 1.24.0\n",
+		"gnob.go": string(src),
+		"main.go": `//go:build gnob
+
+package main
+
+import (
+	"context"
+	"os"
+)
+
+func main() {
+	mf := GnobLib.Makefile.New(GnobMakeTarget{
+		Name: "touch",
+		Desc: "create files",
+		Body: func(ctx context.Context, mf *GnobMakefile) error {
+			for _, name := range mf.TargetArgs() {
+				if err := os.WriteFile(name, nil, 0o644); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	mf.AddPattern(GnobPatternTarget{
+		Name: "touch-%",
+		Body: func(ctx context.Context, mf *GnobMakefile, stem string) error {
+			return os.WriteFile(stem+".txt", nil, 0o644)
+		},
+	})
+	mf.Run(context.Background())
+}
+`,
+	}
+	for name, content := range project {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("unable to write %s: %v", name, err)
+		}
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"sub:touch", "a.txt", "sub:touch-b"})
+	if err := mf.Import(t.Context(), "sub", gnoblib.Lib.Makefile.Project(dir)); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if tgt := mf.Find("sub:touch"); tgt == nil || tgt.Desc != "create files" {
+		t.Errorf("Find(sub:touch) = %+v, want imported target", tgt)
+	}
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was not created: %v", name, err)
+		}
+	}
+}
+
+func TestMakefileCompletion(t *testing.T) {
+	targets := []gnoblib.MakeTarget{
+		{
+			Name:  "build",
+			Flags: []gnoblib.TargetFlag{gnoblib.Lib.Makefile.BoolFlag("race", false, "enable the race detector")},
+			Body:  func(ctx context.Context, mf *gnoblib.Makefile) error { return nil },
+		},
+		{Name: "bench", Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }},
+		{Name: "secret", Hidden: true, Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }},
+	}
+	complete := func(args ...string) []string {
+		mf := gnoblib.Lib.Makefile.NewEx("gnob", args, targets...)
+		out := captureStdout(t, func() {
+			if err := mf.RunE(t.Context()); err != nil {
+				t.Errorf("RunE(%q) error = %v", args, err)
+			}
+		})
+		return strings.Fields(out)
+	}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{args: []string{"__complete", ""}, want: []string{"bench", "build"}},
+		{args: []string{"__complete", "bu"}, want: []string{"build"}},
+		{args: []string{"__complete", "-p"}, want: []string{"-parallel"}},
+		{args: []string{"__complete", "-C", ""}, want: nil},
+		{args: []string{"__complete", "-j", "4", "build", "--r"}, want: []string{"--race"}},
+		{args: []string{"__complete", "build", "--", ""}, want: nil},
+	}
+	for _, tt := range tests {
+		if got := complete(tt.args...); !slices.Equal(got, tt.want) {
+			t.Errorf("complete(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		if script := strings.Join(complete("-completion", shell), " "); !strings.Contains(script, "__complete") {
+			t.Errorf("completion script for %s does not call __complete:\n%s", shell, script)
+		}
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-completion", "tcsh"}, targets...)
+	if err := mf.RunE(t.Context()); err == nil {
+		t.Errorf("RunE() error = nil, want unknown shell")
+	}
+}
+
+func TestMakefileWatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	interval := gnoblib.WatchInterval
+	gnoblib.WatchInterval = 10 * time.Millisecond
+	t.Cleanup(func() { gnoblib.WatchInterval = interval })
+	if err := os.WriteFile("src.txt", []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var runs atomic.Int32
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-watch", "build"},
+		gnoblib.MakeTarget{
+			Name:     "build",
+			Outputs:  []string{"out.txt"},
+			UpToDate: gnoblib.Lib.Makefile.FileUpToDate("out.txt", "src.txt"),
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				runs.Add(1)
+				return os.WriteFile("out.txt", []byte("built"), 0o644)
+			},
+		},
+	)
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- mf.RunE(ctx) }()
+	waitFor := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for runs.Load() < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("runs = %d, want %d", runs.Load(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(1)
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile("src.txt", []byte("v2 with more"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes("src.txt", future, future); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(2)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("runs = %d, want 2", n)
+	}
+}
+
+func TestMakefileNoDefault(t *testing.T) {
+	var ran []string
+	target := func(name string, hidden bool) gnoblib.MakeTarget {
+		return gnoblib.MakeTarget{
+			Name:   name,
+			Desc:   name + " target",
+			Hidden: hidden,
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				ran = append(ran, name)
+				return nil
+			},
+		}
+	}
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", nil, target("build", false), target("all", false), target("secret", true))
+	err := mf.RunE(t.Context())
+	if err == nil {
+		t.Fatalf("RunE() error = nil, want no default target")
+	}
+	for _, want := range []string{"no default target", "all target", "build target"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("RunE() error = %q, want it to contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("RunE() error = %q, want hidden targets not listed", err)
+	}
+	if len(ran) > 0 {
+		t.Errorf("ran = %q, want no target executed", ran)
+	}
+
+	defaultTarget := target("build", false)
+	defaultTarget.Default = true
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", nil, target("all", false), defaultTarget)
+	if err = mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if !slices.Equal(ran, []string{"build"}) {
+		t.Errorf("ran = %q, want [build]", ran)
+	}
+}
+
+func TestMakefileValidate(t *testing.T) {
+	body := func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"tset"},
+		gnoblib.MakeTarget{Name: "test", Body: body},
+		gnoblib.MakeTarget{Name: "docker:build", Body: body},
+		gnoblib.MakeTarget{Name: "lint", Body: body},
+	)
+	err := mf.RunE(t.Context())
+	if want := "unknown target: tset (did you mean test?)"; err == nil || err.Error() != want {
+		t.Errorf("RunE() error = %v, want %q", err, want)
+	}
+	if err = mf.Depend(t.Context(), "biuld"); err == nil || !strings.Contains(err.Error(), "did you mean docker:build?") {
+		t.Errorf("Depend(biuld) error = %v, want a suggestion of docker:build", err)
+	}
+	if err = mf.Depend(t.Context(), "deploy"); err == nil || strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("Depend(deploy) error = %v, want no suggestion", err)
+	}
+
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"a"},
+		gnoblib.MakeTarget{Name: "a", Default: true, Deps: []string{"bb"}, Body: body},
+		gnoblib.MakeTarget{Name: "b", Default: true, Body: body},
+		gnoblib.MakeTarget{Name: "A", Body: body},
+		gnoblib.MakeTarget{Name: "c"},
+	)
+	err = mf.Validate()
+	if err == nil {
+		t.Fatalf("Validate() error = nil, want errors")
+	}
+	for _, want := range []string{
+		"duplicate target: A",
+		"multiple default targets: a, b",
+		"target c: no Body",
+		"target a: unknown dependency: bb (did you mean b?)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %q, want it to contain %q", err, want)
+		}
+	}
+	if runErr := mf.RunE(t.Context()); runErr == nil || runErr.Error() != err.Error() {
+		t.Errorf("RunE() error = %v, want %v", runErr, err)
+	}
+}
+
+func TestMakefileDocs(t *testing.T) {
+	body := func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"-gen-docs=markdown"},
+		gnoblib.MakeTarget{
+			Name:     "build",
+			Desc:     "build the binary",
+			LongDesc: "Builds the binary.",
+			Default:  true,
+			Deps:     []string{"generate"},
+			Flags:    []gnoblib.TargetFlag{gnoblib.Lib.Makefile.StringFlag("tags", "netgo", "build tags")},
+			Body:     body,
+		},
+		gnoblib.MakeTarget{Name: "generate", Desc: ".generate code", Body: body},
+		gnoblib.MakeTarget{Name: "secret", Hidden: true, Body: body},
+	)
+	out := captureStdout(t, func() {
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Errorf("RunE() error = %v", err)
+		}
+	})
+	for _, want := range []string{
+		"## `build` (default)\n\nbuild the binary\n\nBuilds the binary.\n\nDependencies: `generate`",
+		"| `-tags` | `netgo` | build tags |",
+		"## `generate`",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown docs do not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("markdown docs contain a hidden target:\n%s", out)
+	}
+
+	var sb strings.Builder
+	if err := mf.WriteDocs(&sb, "man"); err != nil {
+		t.Fatalf("WriteDocs(man) error = %v", err)
+	}
+	for _, want := range []string{".TH GNOB 1", ".B build (default)", `\fB\-tags\fR (default netgo): build tags`, `\&.generate code`} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("man docs do not contain %q:\n%s", want, sb.String())
+		}
+	}
+
+	tplFile := filepath.Join(t.TempDir(), "docs.tpl")
+	if err := os.WriteFile(tplFile, []byte(`{{ range .Targets }}{{ .Name }}={{ join .Deps "," }};{{ end }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sb.Reset()
+	if err := mf.WriteDocs(&sb, tplFile); err != nil {
+		t.Fatalf("WriteDocs(%s) error = %v", tplFile, err)
+	}
+	if got, want := sb.String(), "build=generate;generate=;"; got != want {
+		t.Errorf("WriteDocs(%s) = %q, want %q", tplFile, got, want)
+	}
+	if err := mf.WriteDocs(&sb, "html"); err == nil {
+		t.Errorf("WriteDocs(html) error = nil, want unknown format")
+	}
+}
+
+func TestMakefileVars(t *testing.T) {
+	t.Chdir(t.TempDir())
+	env := "# build settings\nGOOS=windows\nexport CGO_ENABLED=1\nLDFLAGS=\"-s -w\"\n"
+	if err := os.WriteFile("gnob.env", []byte(env), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOARCH", "arm64")
+	t.Setenv("CGO_ENABLED", "0")
+	var got map[string]any
+	mf := gnoblib.Lib.Makefile.NewEx("gnob", []string{"build", "-ldflags", "X=1", "GOOS=linux", "-race", "pkg", "--", "X=1"},
+		gnoblib.MakeTarget{
+			Name: "build",
+			Flags: []gnoblib.TargetFlag{
+				gnoblib.Lib.Makefile.StringFlag("ldflags", "", "linker flags"),
+				gnoblib.Lib.Makefile.BoolFlag("race", false, "race detector"),
+			},
+			Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+				got = map[string]any{
+					"X":           mf.Var("X"),
+					"ldflags":     mf.String("ldflags"),
+					"race":        mf.Bool("race"),
+					"GOOS":        mf.Var("GOOS"),
+					"GOARCH":      mf.Var("GOARCH"),
+					"CGO_ENABLED": mf.VarBool("CGO_ENABLED"),
+					"LDFLAGS":     mf.Var("LDFLAGS"),
+					"JOBS":        mf.VarInt("JOBS"),
+					"TIMEOUT":     mf.VarDuration("TIMEOUT"),
+					"args":        strings.Join(mf.TargetArgs(), " "),
+				}
+				return nil
+			},
+		},
+	)
+	mf.AddVars(
+		gnoblib.MakeVar{Name: "GOOS", Default: "darwin", Usage: "target operating system"},
+		gnoblib.MakeVar{Name: "JOBS", Default: "4", Usage: "number of jobs"},
+		gnoblib.MakeVar{Name: "TIMEOUT", Default: "1m", Usage: "test timeout"},
+	)
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	want := map[string]any{
+		"X":           "",
+		"ldflags":     "X=1",
+		"race":        true,
+		"GOOS":        "linux",
+		"GOARCH":      "arm64",
+		"CGO_ENABLED": false,
+		"LDFLAGS":     "-s -w",
+		"JOBS":        4,
+		"TIMEOUT":     time.Minute,
+		"args":        "pkg X=1",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("variables = %v, want %v", got, want)
+	}
+
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"-help"},
+		gnoblib.MakeTarget{Name: "build", Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }},
+	)
+	mf.AddVars(
+		gnoblib.MakeVar{Name: "GOOS", Default: "darwin", Usage: "target operating system"},
+		gnoblib.MakeVar{Name: "JOBS", Default: "4", Usage: "number of jobs"},
+	)
+	out := captureStdout(t, func() {
+		if err := mf.RunE(t.Context()); err != nil {
+			t.Errorf("RunE() error = %v", err)
+		}
+	})
+	for _, want := range []string{"Variables:", "GOOS=windows (file)   target operating system", "JOBS=4                number of jobs"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("help does not contain %q:\n%s", want, out)
+		}
+	}
+
+	if err := os.WriteFile("vars.json", []byte(`{"GOOS": "plan9", "JOBS": 8}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"-vars", "vars.json", "build"},
+		gnoblib.MakeTarget{Name: "build", Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+			got = map[string]any{"GOOS": mf.Var("GOOS"), "JOBS": mf.VarInt("JOBS")}
+			return nil
+		}},
+	)
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if want := map[string]any{"GOOS": "plan9", "JOBS": 8}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("variables = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile("vars.json", []byte(`{"JOBS": 1000000, "RACE": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"-vars", "vars.json", "build"},
+		gnoblib.MakeTarget{Name: "build", Body: func(ctx context.Context, mf *gnoblib.Makefile) error {
+			got = map[string]any{"JOBS": mf.Var("JOBS"), "RACE": mf.VarBool("RACE")}
+			return nil
+		}},
+	)
+	if err := mf.RunE(t.Context()); err != nil {
+		t.Fatalf("RunE() error = %v", err)
+	}
+	if want := map[string]any{"JOBS": "1000000", "RACE": true}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("variables = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile("vars.json", []byte(`{"TAGS": ["a", "b"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mf = gnoblib.Lib.Makefile.NewEx("gnob", []string{"-vars", "vars.json", "build"},
+		gnoblib.MakeTarget{Name: "build", Body: func(ctx context.Context, mf *gnoblib.Makefile) error { return nil }},
+	)
+	if err := mf.RunE(t.Context()); err == nil || !strings.Contains(err.Error(), "variable TAGS") {
+		t.Errorf("RunE() error = %v, want error on array variable", err)
+	}
+}
@@ -24,17 +24,18 @@ func main() {
 		GnobMakeTarget{
 			Name:    "all",
 			Default: true,
+			Deps:    []string{"gnob.go", "example"},
 			Body: func(ctx context.Context, mf *GnobMakefile) error {
-				return mf.Depend(ctx, "gnob.go", "example")
+				return nil
 			},
 		},
 		GnobMakeTarget{
-			Name:     "gnob.go",
-			UpToDate: makefile.FileUpToDate("gnob.go", "internal/gnoblib/*.go"),
+			Name:         "gnob.go",
+			Deps:         []string{"internal/gnoblib/a.go"},
+			Inputs:       []string{"internal/gnoblib/*.go"},
+			Outputs:      []string{"gnob.go"},
+			EchoCommands: true,
 			Body: func(ctx context.Context, mf *GnobMakefile) error {
-				if err := mf.Depend(ctx, "internal/gnoblib/a.go"); err != nil {
-					return err
-				}
 				logger.Info("Building gnob.go")
 				if err := cmd.Exec(ctx, "go", "tool", "golang.org/x/tools/cmd/bundle",
 					"-o", "gnob.go",
@@ -49,15 +50,11 @@ func main() {
 			},
 		},
 		GnobMakeTarget{
-			Name: "internal/gnoblib/a.go",
-			UpToDate: makefile.FileUpToDate(
-				"internal/gnoblib/a.go",
-				"README.md",
-				"templates/a.go.tpl"),
+			Name:    "internal/gnoblib/a.go",
+			Deps:    []string{"README.md"},
+			Inputs:  []string{"README.md", "templates/a.go.tpl"},
+			Outputs: []string{"internal/gnoblib/a.go"},
 			Body: func(ctx context.Context, mf *GnobMakefile) error {
-				if err := mf.Depend(ctx, "README.md"); err != nil {
-					return err
-				}
 				logger.Info("Building internal/gnoblib/a.go")
 				tt, err := tmpl.ParseFile("templates/a.go.tpl")
 				if err != nil {
@@ -71,13 +68,14 @@ func main() {
 		},
 		GnobMakeTarget{
 			Name: "README.md",
-			UpToDate: makefile.FileUpToDate("README.md",
+			Inputs: []string{
 				"gnob.go",
 				"templates/*.tpl",
 				"templates/cmdpipe/*",
 				"templates/makefile/*",
 				"templates/usage/*",
-			),
+			},
+			Outputs: []string{"README.md"},
 			Body: func(ctx context.Context, mf *GnobMakefile) error {
 				logger.Info("Building README.md")
 				templates := []string{
@@ -102,6 +100,10 @@ func main() {
 		},
 		GnobMakeTarget{
 			Name: "test",
+			UpToDate: makefile.StateUpToDate(
+				[]string{"go.mod", "internal/gnoblib/*.go", "internal/gnobtest/*.go", "internal/gnobtest/cmd/*.go"},
+				[]string{"cover.out"}),
+			EchoCommands: true,
 			Body: func(ctx context.Context, mf *GnobMakefile) error {
 				if err := cmd.Exec(ctx, "go", "generate", "./internal/gnobtest").Run(); err != nil {
 					return err
@@ -110,28 +112,21 @@ func main() {
 			},
 		},
 		GnobMakeTarget{
-			Name: "example",
+			Name:         "example",
+			EchoCommands: true,
 			Body: func(ctx context.Context, mf *GnobMakefile) error {
-				return mf.Depend(ctx, "examples/docs", "examples/general", "examples/gnobmake")
+				return mf.DependParallel(ctx, "examples/docs", "examples/general", "examples/gnobmake")
 			},
 		})
-	mf.Add(exampleTargets("docs")...)
-	mf.Add(exampleTargets("general")...)
-	mf.Add(exampleTargets("gnobmake")...)
-	mf.Run(context.Background())
-}
-
-func exampleTargets(name string) []GnobMakeTarget {
-	exampleDir := filepath.Join("examples", name)
-	exampleGnob := filepath.Join(exampleDir, "gnob")
-	exampleGnobGo := filepath.Join(exampleDir, "gnob.go")
-	return []GnobMakeTarget{
-		{
-			Name:     exampleGnob,
-			UpToDate: makefile.FileUpToDate(exampleGnobGo, "gnob.go"),
-			Body: func(ctx context.Context, mf *GnobMakefile) error {
+	mf.AddPattern(
+		GnobPatternTarget{
+			Name:    "examples/%/gnob",
+			Inputs:  []string{"gnob.go"},
+			Outputs: []string{"examples/%/gnob.go"},
+			Body: func(ctx context.Context, mf *GnobMakefile, name string) error {
+				exampleDir := filepath.Join("examples", name)
 				logger.Info("[example] Building example", "example", name)
-				if err := files.CopyFile(exampleGnobGo, "gnob.go", 0); err != nil {
+				if err := files.CopyFile(filepath.Join(exampleDir, "gnob.go"), "gnob.go", 0); err != nil {
 					return err
 				}
 				if err := cmd.Exec(ctx, "go", "generate", "-C", exampleDir, ".").Run(); err != nil {
@@ -140,18 +135,15 @@ func exampleTargets(name string) []GnobMakeTarget {
 				return nil
 			},
 		},
-		{
-			Name: exampleDir,
-			Body: func(ctx context.Context, mf *GnobMakefile) error {
-				if err := mf.Depend(ctx, exampleGnob); err != nil {
-					return err
-				}
+		GnobPatternTarget{
+			Name:         "examples/%",
+			Deps:         []string{"examples/%/gnob"},
+			EchoCommands: true,
+			Body: func(ctx context.Context, mf *GnobMakefile, name string) error {
 				logger.Info("[example] Testing example", "example", name)
-				if err := cmd.Exec(ctx, "make", "-C", exampleDir, "test").Run(); err != nil {
-					return err
-				}
-				return nil
+				// examples without targets, like docs, ignore the name of the target and just run
+				return makefile.Project(filepath.Join("examples", name)).Run(ctx, "test")
 			},
-		},
-	}
+		})
+	mf.Run(context.Background())
 }